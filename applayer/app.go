@@ -1,24 +1,27 @@
 package applayer
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 
+	"Pobeda/datalayer"
+
 	"github.com/gorilla/websocket"
 	"github.com/satori/go.uuid"
 )
 
 var (
-	// for handling multiple browser sessions
-	clients = make(map[string]Client, 2)
+	clients = newHub() // browser sessions
 )
 
 func Connect(w http.ResponseWriter, r *http.Request) {
+	role, ok := authorize(w, r)
+	if !ok {
+		return
+	}
 	u := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		CheckOrigin:  allowedOrigin,
+		Subprotocols: subprotocols,
 	}
 
 	conn, err := u.Upgrade(w, r, nil)
@@ -27,31 +30,25 @@ func Connect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	handle(conn)
+	handle(conn, role)
 }
 
-func handle(conn *websocket.Conn) {
-	c := Client{
+func handle(conn *websocket.Conn, role string) {
+	proto := conn.Subprotocol()
+	if proto == "" {
+		proto = protoV1 // client hasn't asked for any
+	}
+	c := &Client{
 		uuid:  uuid.NewV4().String(),
+		proto: proto,
+		role:  role,
 		conn:  conn,
 		sendC: make(chan []byte, queueLen),
 	}
+	clients.register(c)
 	go c.Listen()
 	go c.Send()
-	clients[c.uuid] = c
-}
-
-func send(m *wsSendFrame) {
-	j, err := json.Marshal(m)
-	if err != nil {
-		log.Printf("cannot json marshal %T", m)
-		return
-	}
-	for _, v := range clients {
-		v.sendC <- j
-	}
-}
 
-func disconnect(uuid string) {
-	delete(clients, uuid)
+	// new client knows nothing, events are incremental after snapshot
+	datalayer.GetActionStatusFromApp(c.reqOf(""), datalayer.OP_SNAPSHOT, "", nil, "")
 }
@@ -0,0 +1,148 @@
+package applayer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"Pobeda/datalayer"
+)
+
+// TokenFile keeps operator token generated on the first start, in the state dir.
+const TokenFile = "token"
+
+// roles of clients
+const (
+	RoleObserver = "observer" // reads state and events
+	RoleOperator = "operator" // performs any op
+)
+
+// v2 and REST error codes of access
+const (
+	errUnauthorized = "unauthorized"
+	errForbidden    = "forbidden"
+)
+
+// readOps are allowed to observers.
+var readOps = map[byte]bool{
+	datalayer.OP_STATS:    true,
+	datalayer.OP_SNAPSHOT: true,
+}
+
+// Auth is access policy of websocket, REST and SSE clients. Token is passed in
+// "Authorization: Bearer" header or "token" query parameter, browsers can't
+// set headers of websocket. Zero Auth lets everyone be operator.
+type Auth struct {
+	Origins   []string          // allowed origins of browsers, "*" is any, same origin if empty
+	Tokens    map[string]string // role by token
+	Anonymous string            // role of clients without token, they are rejected if empty
+}
+
+var auth Auth
+
+type trustedKey struct{}
+
+// Trusted lets requests of h be operators, e.g. on the control socket
+// protected by file permissions.
+func Trusted(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), trustedKey{}, true)))
+	})
+}
+
+// authorize returns role of the request, rejected request is answered.
+func authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Context().Value(trustedKey{}) != nil {
+		return RoleOperator, true
+	}
+	if !allowedOrigin(r) {
+		log.Printf("origin %s is not allowed", r.Header.Get("Origin"))
+		writeError(w, http.StatusForbidden, &wsError{Code: errForbidden, Message: "origin is not allowed"})
+		return "", false
+	}
+	role := roleOf(r)
+	if role == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, &wsError{Code: errUnauthorized, Message: "token is missing or wrong"})
+		return "", false
+	}
+
+	return role, true
+}
+
+// roleOf returns empty role for wrong token.
+func roleOf(r *http.Request) string {
+	if len(auth.Tokens) == 0 {
+		return RoleOperator
+	}
+	token := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	if token == "" {
+		return auth.Anonymous
+	}
+	for t, role := range auth.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return role
+		}
+	}
+
+	return ""
+}
+
+// allowedOrigin is true for clients which aren't browsers, they don't send Origin.
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range auth.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	if len(auth.Origins) != 0 {
+		return false
+	}
+	u, err := url.Parse(origin)
+
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// LoadToken reads operator token from the state dir, it's generated and saved
+// on the first start. Its path is logged, the token is not.
+func LoadToken(dir string) (string, error) {
+	path := filepath.Join(dir, TokenFile)
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		log.Printf("operator token is in %s", path)
+		return strings.TrimSpace(string(b)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	log.Printf("operator token is generated in %s", path)
+
+	return token, nil
+}
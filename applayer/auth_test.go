@@ -0,0 +1,83 @@
+package applayer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Pobeda/datalayer"
+)
+
+func TestAuthorize(t *testing.T) {
+	defer func(a Auth) { auth = a }(auth)
+	auth = Auth{
+		Tokens:    map[string]string{"op": RoleOperator, "obs": RoleObserver},
+		Anonymous: RoleObserver,
+	}
+
+	for i, c := range []struct {
+		method string
+		target string
+		header map[string]string
+		code   int // of API, 405 means the request has passed
+		role   string
+	}{
+		{http.MethodPost, "/api/status?token=op", nil, http.StatusMethodNotAllowed, RoleOperator},
+		{http.MethodPost, "/api/status", map[string]string{"Authorization": "Bearer op"}, http.StatusMethodNotAllowed, RoleOperator},
+		{http.MethodPost, "/api/status?token=obs", nil, http.StatusForbidden, RoleObserver},
+		{http.MethodPost, "/api/status", nil, http.StatusForbidden, RoleObserver},
+		{http.MethodGet, "/api/ring", nil, http.StatusMethodNotAllowed, RoleObserver},
+		{http.MethodGet, "/api/ring?token=wrong", nil, http.StatusUnauthorized, ""},
+		{http.MethodGet, "/api/ring", map[string]string{"Origin": "http://example.com"}, http.StatusMethodNotAllowed, RoleObserver},
+		{http.MethodGet, "/api/ring", map[string]string{"Origin": "http://evil.com"}, http.StatusForbidden, ""},
+	} {
+		r := httptest.NewRequest(c.method, c.target, nil) // host is example.com
+		for k, v := range c.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		API().ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("[%d] wrong code %d, expected %d", i, w.Code, c.code)
+		}
+		role, _ := authorize(httptest.NewRecorder(), r)
+		if role != c.role {
+			t.Errorf("[%d] wrong role '%s', expected '%s'", i, role, c.role)
+		}
+	}
+
+	auth.Anonymous, auth.Origins = "", []string{"https://ui.example.org"}
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.Header.Set("Origin", "https://ui.example.org")
+	if role := roleOf(r); role != "" {
+		t.Errorf("anonymous is %s, expected to be rejected", role)
+	}
+	if !allowedOrigin(r) {
+		t.Errorf("configured origin is not allowed")
+	}
+	r.Header.Set("Origin", "http://example.com")
+	if allowedOrigin(r) {
+		t.Errorf("same origin is allowed, but origins are configured")
+	}
+	Trusted(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role, ok := authorize(w, r); role != RoleOperator || !ok {
+			t.Errorf("trusted request is %s", role)
+		}
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/status", nil))
+}
+
+func TestForbid(t *testing.T) {
+	defer func(h *hub) { clients = h }(clients)
+	clients = newHub()
+	c := &Client{uuid: "a", proto: protoV2, role: RoleObserver, sendC: make(chan []byte, 4)}
+	clients.register(c)
+
+	processWSFrame(c, &wsFrame{Type: datalayer.OP_KILL_RING}, c.reqOf("1"))
+	if len(c.sendC) != 1 {
+		t.Fatalf("got %d frames, expected 1", len(c.sendC))
+	}
+	if m := string(<-c.sendC); !strings.Contains(m, `"code":"forbidden"`) || !strings.Contains(m, `"id":"1"`) {
+		t.Errorf("wrong error %s", m)
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"encoding/json"
 	"log"
 
+	"Pobeda/datalayer"
+	"Pobeda/logs"
+
 	"github.com/gorilla/websocket"
 )
 
 type Client struct {
 	uuid  string
+	proto string // negotiated subprotocol, protoV1 or protoV2
+	role  string // RoleObserver or RoleOperator
 	conn  *websocket.Conn
 	sendC chan []byte
 }
@@ -22,24 +27,90 @@ func (c *Client) Listen() {
 			} else {
 				log.Printf("listen: client %s unknown err: %s", c.uuid, err)
 			}
-			disconnect(c.uuid)
-			close(c.sendC)
+			clients.unregister(c.uuid)
+			c.conn.Close()
 			return
 		}
 
+		if c.proto == protoV2 {
+			c.processRequest(raw)
+			continue
+		}
 		m := &wsFrame{}
 		if err = json.Unmarshal(raw, m); err != nil {
 			log.Println("cannot unmarshal", err)
 			continue
 		}
 
-		processWSFrame(m)
+		processWSFrame(c, m, c.reqOf(""))
+	}
+}
+
+// processRequest performs v2 request, it's translated to v1 frame.
+func (c *Client) processRequest(raw []byte) {
+	r := &wsRequest{}
+	if err := json.Unmarshal(raw, r); err != nil {
+		log.Println("cannot unmarshal", err)
+		clients.reply(c, &wsEvent{
+			Event: events[datalayer.ERROR],
+			Error: &wsError{Code: errBadFrame, Message: err.Error()},
+		})
+		return
+	}
+	op, ok := ops[r.Op]
+	if !ok {
+		log.Printf("unknown ws op '%s'", r.Op)
+		clients.reply(c, &wsEvent{
+			Event: events[datalayer.ERROR],
+			ID:    r.ID,
+			Error: &wsError{Code: errUnknownOp, Message: "unknown op " + r.Op},
+		})
+		return
+	}
+
+	processWSFrame(c, &wsFrame{Type: op, Payload: r.Payload}, c.reqOf(r.ID))
+}
+
+// forbid reports op which isn't allowed to the role of the client: v1 client
+// gets ErrProtocolBug, it has no better one.
+func (c *Client) forbid(req string, op byte) {
+	log.Printf("client %s: op %d is not allowed to %s", c.uuid, op, c.role)
+	if c.proto != protoV2 {
+		clients.sendTo(c.uuid, &wsSendFrame{
+			Type:    datalayer.ERROR,
+			Payload: datalayer.ActionPayload{Message: datalayer.ErrProtocolBug, Req: req},
+		})
+		return
+	}
+	clients.reply(c, &wsEvent{
+		Event: events[datalayer.ERROR],
+		ID:    c.idOf(req),
+		Error: &wsError{Code: errForbidden, Message: "operator role is required"},
+	})
+}
+
+// fail reports wrong payload of the request to the client: v1 client gets
+// ErrProtocolBug, v2 client gets the reason.
+func (c *Client) fail(req string, err error) {
+	if c.proto != protoV2 {
+		clients.sendTo(c.uuid, &wsSendFrame{
+			Type:    datalayer.ERROR,
+			Payload: datalayer.ActionPayload{Message: datalayer.ErrProtocolBug, Req: req},
+		})
+		return
 	}
+	we := wsErrors[datalayer.ErrProtocolBug]
+	we.Details = map[string]string{"reason": err.Error()}
+	clients.reply(c, &wsEvent{
+		Event: events[datalayer.ERROR],
+		ID:    c.idOf(req),
+		Error: &we,
+	})
 }
 
 func (c *Client) Send() {
 	for m := range c.sendC {
-		log.Printf("finally sending %s", string(m))
+		logs.Debugf("finally sending %s", string(m))
 		err := c.conn.WriteMessage(websocket.TextMessage, m)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err) {
@@ -47,7 +118,8 @@ func (c *Client) Send() {
 			} else {
 				log.Printf("send: client %s unknown err: %s", c.uuid, err)
 			}
-			disconnect(c.uuid)
+			clients.unregister(c.uuid)
+			c.conn.Close() // Listen exits
 			return
 		}
 	}
@@ -2,6 +2,7 @@ package applayer
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 
 	"Pobeda/com"
@@ -15,41 +16,79 @@ type wsFrame struct {
 
 type wsSendFrame struct {
 	Type    byte        `json:"type"`
+	Seq     uint64      `json:"seq,omitempty"` // of broadcast event, gap means it's lost
 	Payload interface{} `json:"payload"`
 }
 
-func processWSFrame(f *wsFrame) {
+// processWSFrame performs op of the client, req is echoed in its statuses.
+func processWSFrame(c *Client, f *wsFrame, req string) {
+	if c.role != RoleOperator && !readOps[f.Type] {
+		c.forbid(req, f.Type)
+		return
+	}
 	switch f.Type {
 	case datalayer.OP_CONNECT:
 		cfg := &com.Config{}
 		if err := json.Unmarshal(f.Payload, cfg); err != nil {
 			log.Printf("OP_CONNECT: cannot read payload %+v: %s", f.Payload, err)
-			datalayer.SendActionStatusToApp(datalayer.ERROR, "", "", datalayer.ErrProtocolBug)
+			c.fail(req, err)
 			return
 		}
-		datalayer.GetActionStatusFromApp(datalayer.OP_CONNECT, "", cfg, "")
+		datalayer.GetActionStatusFromApp(req, datalayer.OP_CONNECT, "", cfg, "")
 	case datalayer.OP_RING_CONNECT:
-		datalayer.GetActionStatusFromApp(datalayer.OP_RING_CONNECT, "", nil, "")
+		datalayer.GetActionStatusFromApp(req, datalayer.OP_RING_CONNECT, "", nil, "")
 	case datalayer.OP_SEND:
 		m := &message{}
 		if err := json.Unmarshal(f.Payload, m); err != nil {
 			log.Printf("OP_SEND: cannot read payload %+v: %s", f.Payload, err)
-			datalayer.SendActionStatusToApp(datalayer.ERROR, "", "", datalayer.ErrProtocolBug)
+			c.fail(req, err)
 			return
 		}
-		datalayer.GetActionStatusFromApp(datalayer.OP_SEND, m.Addr, nil, m.Message)
+		datalayer.GetMessageFromApp(req, m.ID, m.Addr, m.Message)
 	case datalayer.OP_DISCONNECT:
 		var a datalayer.SystemAction
 		if err := json.Unmarshal(f.Payload, &a); err != nil {
 			log.Printf("OP_DISCONNECT: cannot cast payload %+v to string: %s", f.Payload, err)
-			datalayer.SendActionStatusToApp(datalayer.ERROR, "", "", datalayer.ErrProtocolBug)
+			c.fail(req, err)
+			return
+		}
+		datalayer.GetActionStatusFromApp(req, datalayer.OP_DISCONNECT, a.Addr, nil, "")
+	case datalayer.OP_KILL_RING, datalayer.OP_JOIN_RING, datalayer.OP_LEAVE_RING, datalayer.OP_STATS, datalayer.OP_SNAPSHOT:
+		datalayer.GetActionStatusFromApp(req, f.Type, "", nil, "")
+	case datalayer.OP_GROUP_CREATE, datalayer.OP_GROUP_JOIN, datalayer.OP_GROUP_LEAVE:
+		var a datalayer.SystemAction
+		if err := json.Unmarshal(f.Payload, &a); err != nil {
+			log.Printf("group op %d: cannot read payload %+v: %s", f.Type, f.Payload, err)
+			c.fail(req, err)
+			return
+		}
+		datalayer.GetGroupActionFromApp(req, f.Type, a.Group)
+	case datalayer.OP_AUTO_RING:
+		var a datalayer.SystemAction
+		if err := json.Unmarshal(f.Payload, &a); err != nil {
+			log.Printf("OP_AUTO_RING: cannot read payload %+v: %s", f.Payload, err)
+			c.fail(req, err)
+			return
+		}
+		datalayer.GetAutoRingFromApp(req, a.Auto)
+	case datalayer.OP_SET_NICK:
+		var n nick
+		if err := json.Unmarshal(f.Payload, &n); err != nil {
+			log.Printf("OP_SET_NICK: cannot read payload %+v: %s", f.Payload, err)
+			c.fail(req, err)
+			return
+		}
+		datalayer.GetActionStatusFromApp(req, datalayer.OP_SET_NICK, "", nil, n.Nick)
+	case datalayer.OP_GROUP_SEND:
+		m := &message{}
+		if err := json.Unmarshal(f.Payload, m); err != nil {
+			log.Printf("OP_GROUP_SEND: cannot read payload %+v: %s", f.Payload, err)
+			c.fail(req, err)
 			return
 		}
-		datalayer.GetActionStatusFromApp(datalayer.OP_DISCONNECT, a.Addr, nil, "")
-	case datalayer.OP_KILL_RING:
-		datalayer.GetActionStatusFromApp(datalayer.OP_KILL_RING, "", nil, "")
+		datalayer.GetGroupMessageFromApp(req, m.ID, m.Group, m.Message)
 	default:
 		log.Printf("unknown ws frame type '%d'", f.Type)
-		datalayer.SendActionStatusToApp(datalayer.ERROR, "", "", datalayer.ErrProtocolBug)
+		c.fail(req, fmt.Errorf("unknown frame type %d", f.Type))
 	}
 }
@@ -0,0 +1,184 @@
+package applayer
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+
+	"Pobeda/datalayer"
+)
+
+// statuses which answer the request, they go to the requesting client only
+var responses = map[byte]bool{
+	datalayer.ERROR:    true,
+	datalayer.PENDING:  true,
+	datalayer.ACK:      true,
+	datalayer.NO_ACK:   true,
+	datalayer.TIMEOUT:  true,
+	datalayer.STATS:    true,
+	datalayer.SNAPSHOT: true,
+}
+
+// hub keeps browser sessions: they are registered by http handlers and
+// unregistered by client goroutines or evicted when they are too slow.
+// sendC is written and closed only under mu, so it's never written after close.
+type hub struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+	waiters map[string]chan *wsSendFrame // by request, see wait
+	streams map[*stream]bool             // SSE subscribers
+	kept    []*sseEvent                  // last events for SSE resume
+	seq     uint64                       // of the last broadcast event
+	sseID   uint64                       // of the last SSE event
+}
+
+func newHub() *hub {
+	return &hub{
+		clients: make(map[string]*Client, 2),
+		waiters: make(map[string]chan *wsSendFrame),
+		streams: make(map[*stream]bool),
+	}
+}
+
+// wait returns statuses of the request which is not made by ws client, e.g. by REST.
+// Statuses are dropped if the waiter is slow, done stops waiting.
+func (h *hub) wait(req string) (statuses <-chan *wsSendFrame, done func()) {
+	c := make(chan *wsSendFrame, queueLen)
+	h.mu.Lock()
+	h.waiters[req] = c
+	h.mu.Unlock()
+
+	return c, func() {
+		h.mu.Lock()
+		delete(h.waiters, req)
+		h.mu.Unlock()
+	}
+}
+
+func (h *hub) register(c *Client) {
+	h.mu.Lock()
+	h.clients[c.uuid] = c
+	h.mu.Unlock()
+}
+
+// unregister removes the client and closes its sendC, it may be called several times.
+func (h *hub) unregister(uuid string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(uuid)
+}
+
+func (h *hub) remove(uuid string) {
+	c, ok := h.clients[uuid]
+	if !ok {
+		return
+	}
+	delete(h.clients, uuid)
+	close(c.sendC)
+}
+
+// route sends status of data layer: response goes to the client which made
+// the request, other statuses go to everyone numbered by seq. SSE monitors get
+// responses too, but snapshots. Snapshot includes events up to the current seq,
+// so the client skips older ones.
+func (h *hub) route(m *wsSendFrame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, _ := m.Payload.(datalayer.ActionPayload)
+	if p.Snapshot != nil {
+		p.Snapshot.Seq = h.seq
+	}
+	if w, ok := h.waiters[p.Req]; ok {
+		mc := *m // seq is set below
+		select {
+		case w <- &mc:
+		default:
+			log.Printf("waiter of %s is too slow, drop %+v", p.Req, m)
+		}
+	}
+	if responses[m.Type] && p.Req != "" {
+		if c, ok := h.clients[ownerOf(p.Req)]; ok {
+			h.push(c, m)
+		}
+		if m.Type != datalayer.SNAPSHOT {
+			h.publish(m) // without seq, ws clients don't get it
+		}
+		return
+	}
+	h.seq++
+	m.Seq = h.seq
+	for _, c := range h.clients {
+		h.push(c, m)
+	}
+	h.publish(m)
+}
+
+func (h *hub) sendTo(uuid string, m *wsSendFrame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c, ok := h.clients[uuid]; ok {
+		h.push(c, m)
+	}
+}
+
+// reply sends v2 event to the client only.
+func (h *hub) reply(c *Client, e *wsEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c.uuid]; ok {
+		h.write(c, e)
+	}
+}
+
+// push marshals frame in the protocol of the client.
+func (h *hub) push(c *Client, m *wsSendFrame) {
+	if c.proto == protoV2 {
+		h.write(c, c.eventOf(m))
+		return
+	}
+	h.write(c, m)
+}
+
+// write never blocks: client whose queue is full is evicted, data layer doesn't
+// wait for slow browsers.
+func (h *hub) write(c *Client, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("cannot json marshal %T", v)
+		return
+	}
+	select {
+	case c.sendC <- j:
+	default:
+		log.Printf("client %s is too slow, evict it", c.uuid)
+		h.remove(c.uuid)
+		if err := c.conn.Close(); err != nil {
+			log.Printf("close client %s: %s", c.uuid, err)
+		}
+	}
+}
+
+// closeAll disconnects browser sessions, their goroutines unregister them,
+// and ends SSE streams.
+func (h *hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.clients {
+		if err := c.conn.Close(); err != nil {
+			log.Printf("close client %s: %s", c.uuid, err)
+		}
+	}
+	for s := range h.streams {
+		h.drop(s)
+	}
+}
+
+// ownerOf returns uuid of the client which made the request, see Client.reqOf.
+func ownerOf(req string) string {
+	if i := strings.IndexByte(req, '/'); i >= 0 {
+		return req[:i]
+	}
+
+	return req
+}
@@ -0,0 +1,56 @@
+package applayer
+
+import (
+	"testing"
+
+	"Pobeda/datalayer"
+)
+
+func TestHubRoute(t *testing.T) {
+	h := newHub()
+	a := &Client{uuid: "a", proto: protoV1, sendC: make(chan []byte, 4)}
+	b := &Client{uuid: "b", proto: protoV2, sendC: make(chan []byte, 4)}
+	h.register(a)
+	h.register(b)
+
+	// error of a's request goes to a only
+	h.route(&wsSendFrame{
+		Type:    datalayer.ERROR,
+		Payload: datalayer.ActionPayload{Message: datalayer.ErrPhysConnect, Req: a.reqOf("")},
+	})
+	// ring state goes to everyone, even if it's caused by b's request
+	h.route(&wsSendFrame{
+		Type:    datalayer.RING_STATE,
+		Payload: datalayer.ActionPayload{Message: "Linking", Req: b.reqOf("1")},
+	})
+	if got := len(a.sendC); got != 2 {
+		t.Errorf("a got %d frames, expected 2", got)
+	}
+	if got := len(b.sendC); got != 1 {
+		t.Errorf("b got %d frames, expected 1", got)
+	}
+
+	h.unregister("a")
+	h.unregister("a") // by both client goroutines
+	if _, ok := <-a.sendC; !ok {
+		t.Errorf("queued frames are lost")
+	}
+	m := &wsSendFrame{Type: datalayer.QUEUE, Payload: datalayer.ActionPayload{}}
+	h.route(m)
+	if got := len(b.sendC); got != 2 {
+		t.Errorf("b got %d frames, expected 2", got)
+	}
+	if m.Seq != 2 {
+		t.Errorf("wrong seq of broadcast event: got %d, expected 2", m.Seq)
+	}
+
+	// snapshot includes events up to the last broadcast one
+	s := &datalayer.Snapshot{}
+	h.route(&wsSendFrame{
+		Type:    datalayer.SNAPSHOT,
+		Payload: datalayer.ActionPayload{Snapshot: s, Req: b.reqOf("")},
+	})
+	if s.Seq != 2 {
+		t.Errorf("wrong seq of snapshot: got %d, expected 2", s.Seq)
+	}
+}
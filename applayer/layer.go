@@ -1,25 +1,36 @@
 package applayer
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"Pobeda/datalayer"
-)
-
-const (
-	queueLen = 32
+	"Pobeda/logs"
 )
 
 var (
 	L layer
+
+	queueLen = 32 // of every client and stream
 )
 
 type layer struct{}
 
-func (l *layer) listenToDataLinkLayer() {
+// listenToDataLinkLayer works until data layer closes GetAppC or ctx is done.
+func (l *layer) listenToDataLinkLayer(ctx context.Context) {
 	var f *wsSendFrame
-	for a := range datalayer.L.GetAppC {
-		log.Printf("sending frame %+v", a)
+	for {
+		var a *datalayer.Action
+		select {
+		case a = <-datalayer.L.GetAppC:
+		case <-ctx.Done():
+			return
+		}
+		if a == nil {
+			return // data layer is done
+		}
+		logs.Debugf("sending frame %+v", a)
 		status, ok := a.Data.(datalayer.ActionPayload)
 		if !ok {
 			log.Printf("cannot cast %T to datalayer.ActionPayload", a.Data)
@@ -30,11 +41,32 @@ func (l *layer) listenToDataLinkLayer() {
 			Payload: status,
 		}
 
-		send(f)
+		clients.route(f)
 	}
 }
 
-func Init() {
+// Options tune app layer, zero fields are defaults.
+type Options struct {
+	QueueLen   int // of every client
+	Auth       Auth
+	ResultWait time.Duration // of data layer, REST waits a bit longer, see datalayer.ResultWait
+}
+
+// Init starts the layer, it works until data layer is done or ctx is done.
+func Init(ctx context.Context, o Options) {
+	if o.QueueLen > 0 {
+		queueLen = o.QueueLen
+	}
+	auth = o.Auth
+	if o.ResultWait > 0 {
+		restWait = o.ResultWait + restMargin
+	}
 	L = layer{}
-	go L.listenToDataLinkLayer()
+	go L.listenToDataLinkLayer(ctx)
+}
+
+// Close disconnects browser sessions and ends SSE streams,
+// HTTP server doesn't track websockets and waits for streams.
+func Close() {
+	clients.closeAll()
 }
@@ -1,6 +1,12 @@
 package applayer
 
 type message struct {
-	Addr    string `json:"addr"`
+	ID      uint16 `json:"id"`   // optional, assigned by data layer if empty
+	Addr    string `json:"addr"` // ring addr, node id or nickname, empty for broadcast
 	Message string `json:"message"`
+	Group   string `json:"group"` // for OP_GROUP_SEND
+}
+
+type nick struct {
+	Nick string `json:"nick"`
 }
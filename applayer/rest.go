@@ -0,0 +1,282 @@
+package applayer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"Pobeda/com"
+	"Pobeda/datalayer"
+)
+
+// restMargin is added to the wait of data layer results, see Options.
+const restMargin = 5 * time.Second
+
+// restWait is longer than ring connect and delivery of broadcast with rebroadcasts.
+var restWait = datalayer.ResultWait(0, 0) + restMargin
+
+// REST error codes, errors of data layer are the same as in v2
+const (
+	errBadRequest = "bad_request"
+	errMethod     = "method_not_allowed"
+	errNotFound   = "not_found"
+	errTimeout    = "timeout"
+)
+
+// HTTP codes by ERROR message of data layer
+var errorCodes = map[string]int{
+	datalayer.ErrProtocolBug: http.StatusBadRequest,
+	datalayer.ErrPhysConnect: http.StatusBadGateway,
+	datalayer.ErrRingConnect: http.StatusBadGateway,
+	datalayer.ErrGroup:       http.StatusBadRequest,
+	datalayer.ErrNick:        http.StatusBadRequest,
+	datalayer.ErrRingState:   http.StatusConflict,
+	datalayer.ErrMessageID:   http.StatusConflict,
+	datalayer.ErrNotOpen:     http.StatusNotFound,
+}
+
+// HTTP codes by cause of failed message, matched with errors.Is
+var causeCodes = []struct {
+	err  error
+	code int
+	e    wsError
+}{
+	{datalayer.ErrUnknownDest, http.StatusNotFound, wsError{Code: "unknown_dest", Message: "unknown destination"}},
+	{datalayer.ErrNoRing, http.StatusConflict, wsError{Code: "no_ring", Message: "ring is not connected"}},
+}
+
+var restSeq uint64 // makes request ids of REST
+
+// API returns handler of REST API, it performs the same ops as websocket
+// and waits for their results:
+//
+//	GET    /api/ports         open ports
+//	POST   /api/ports         connect to the port, body is com.Config
+//	DELETE /api/ports/{name}  disconnect from the port
+//	POST   /api/ring          connect the ring
+//	DELETE /api/ring          kill the ring
+//	POST   /api/messages      send message and wait for delivery, body is like OP_SEND payload
+//	GET    /api/status        snapshot of the node
+//
+// Observers may only GET.
+func API() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ports", handlePorts)
+	mux.HandleFunc("/api/ports/", handlePort)
+	mux.HandleFunc("/api/ring", handleRing)
+	mux.HandleFunc("/api/messages", handleMessages)
+	mux.HandleFunc("/api/status", handleStatus)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, &wsError{Code: errNotFound, Message: "no such endpoint " + r.URL.Path})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, ok := authorize(w, r)
+		if !ok {
+			return
+		}
+		if role != RoleOperator && r.Method != http.MethodGet {
+			writeError(w, http.StatusForbidden, &wsError{Code: errForbidden, Message: "operator role is required"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func handlePorts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		p, code, ok := call(w, r, snapshot, map[byte]int{datalayer.SNAPSHOT: http.StatusOK})
+		if ok {
+			writeJSON(w, code, p.Snapshot.Ports)
+		}
+	case http.MethodPost:
+		cfg := &com.Config{}
+		if !readJSON(w, r, cfg) {
+			return
+		}
+		if cfg.Name == "" {
+			writeError(w, http.StatusBadRequest, &wsError{Code: errBadRequest, Message: "port name is empty"})
+			return
+		}
+		p, code, ok := call(w, r, func(req string) {
+			datalayer.GetActionStatusFromApp(req, datalayer.OP_CONNECT, "", cfg, "")
+		}, map[byte]int{datalayer.CONNECT: http.StatusCreated})
+		if ok {
+			writeJSON(w, code, p)
+		}
+	default:
+		notAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func handlePort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		notAllowed(w, http.MethodDelete)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/ports/")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, &wsError{Code: errBadRequest, Message: "port name is empty"})
+		return
+	}
+	p, code, ok := call(w, r, func(req string) {
+		datalayer.GetActionStatusFromApp(req, datalayer.OP_DISCONNECT, name, nil, "")
+	}, map[byte]int{datalayer.DISCONNECT: http.StatusOK})
+	if ok {
+		writeJSON(w, code, p)
+	}
+}
+
+func handleRing(w http.ResponseWriter, r *http.Request) {
+	var (
+		op    byte
+		final map[byte]int
+	)
+	switch r.Method {
+	case http.MethodPost:
+		op, final = datalayer.OP_RING_CONNECT, map[byte]int{datalayer.CONNECT_RING: http.StatusOK}
+	case http.MethodDelete:
+		op, final = datalayer.OP_KILL_RING, map[byte]int{datalayer.DISRUPTION: http.StatusOK}
+	default:
+		notAllowed(w, http.MethodPost, http.MethodDelete)
+		return
+	}
+	p, code, ok := call(w, r, func(req string) {
+		datalayer.GetActionStatusFromApp(req, op, "", nil, "")
+	}, final)
+	if ok {
+		writeJSON(w, code, p)
+	}
+}
+
+func handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		notAllowed(w, http.MethodPost)
+		return
+	}
+	m := &message{}
+	if !readJSON(w, r, m) {
+		return
+	}
+	p, code, ok := call(w, r, func(req string) {
+		if m.Group != "" {
+			datalayer.GetGroupMessageFromApp(req, m.ID, m.Group, m.Message)
+			return
+		}
+		datalayer.GetMessageFromApp(req, m.ID, m.Addr, m.Message)
+	}, map[byte]int{
+		datalayer.ACK:     http.StatusOK,
+		datalayer.NO_ACK:  http.StatusBadGateway,
+		datalayer.TIMEOUT: http.StatusGatewayTimeout,
+	})
+	if ok {
+		writeJSON(w, code, p)
+	}
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		notAllowed(w, http.MethodGet)
+		return
+	}
+	p, code, ok := call(w, r, snapshot, map[byte]int{datalayer.SNAPSHOT: http.StatusOK})
+	if ok {
+		writeJSON(w, code, p.Snapshot)
+	}
+}
+
+func snapshot(req string) {
+	datalayer.GetActionStatusFromApp(req, datalayer.OP_SNAPSHOT, "", nil, "")
+}
+
+// call performs op and waits for one of its final statuses, it returns
+// the status payload and its HTTP code. ERROR and timeout are written to w.
+func call(w http.ResponseWriter, r *http.Request, op func(req string), final map[byte]int) (datalayer.ActionPayload, int, bool) {
+	req := fmt.Sprintf("rest/%d", atomic.AddUint64(&restSeq, 1))
+	statuses, done := clients.wait(req)
+	defer done()
+	op(req)
+
+	t := time.NewTimer(restWait)
+	defer t.Stop()
+	for {
+		select {
+		case m := <-statuses:
+			p, _ := m.Payload.(datalayer.ActionPayload)
+			if m.Type == datalayer.ERROR {
+				code, ok := errorCodes[p.Message]
+				if !ok {
+					code = http.StatusInternalServerError
+				}
+				writeError(w, code, errorOf(p))
+				return p, code, false
+			}
+			if code, ok := final[m.Type]; ok {
+				if code, e := causeOf(p); e != nil {
+					writeError(w, code, e)
+					return p, code, false
+				}
+				return p, code, true
+			}
+		case <-t.C:
+			writeError(w, http.StatusGatewayTimeout, &wsError{Code: errTimeout, Message: "no result in " + restWait.String()})
+			return datalayer.ActionPayload{}, http.StatusGatewayTimeout, false
+		case <-r.Context().Done():
+			return datalayer.ActionPayload{}, 0, false
+		}
+	}
+}
+
+// causeOf returns HTTP code and error by cause of failed message, nil if
+// there is no known cause.
+func causeOf(p datalayer.ActionPayload) (int, *wsError) {
+	if p.Err == nil {
+		return 0, nil
+	}
+	for _, c := range causeCodes {
+		if errors.Is(p.Err, c.err) {
+			e := c.e
+			e.Message = p.Message
+			if p.To != "" {
+				e.Details = map[string]string{"to": p.To}
+			}
+			return c.code, &e
+		}
+	}
+
+	return 0, nil
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, &wsError{Code: errBadRequest, Message: err.Error()})
+		return false
+	}
+
+	return true
+}
+
+func notAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, &wsError{Code: errMethod, Message: "method is not allowed"})
+}
+
+func writeError(w http.ResponseWriter, code int, e *wsError) {
+	writeJSON(w, code, struct {
+		Error *wsError `json:"error"`
+	}{e})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("cannot write response: %s", err)
+	}
+}
@@ -0,0 +1,95 @@
+package applayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Pobeda/datalayer"
+)
+
+func TestCall(t *testing.T) {
+	cases := []struct {
+		statuses []wsSendFrame
+		code     int
+		ok       bool
+		errCode  string
+	}{
+		{
+			statuses: []wsSendFrame{
+				{Type: datalayer.RING_STATE},
+				{Type: datalayer.CONNECT_RING, Payload: datalayer.ActionPayload{Message: "3"}},
+			},
+			code: http.StatusOK,
+			ok:   true,
+		},
+		{
+			statuses: []wsSendFrame{
+				{Type: datalayer.ERROR, Payload: datalayer.ActionPayload{Message: datalayer.ErrRingState}},
+			},
+			code:    http.StatusConflict,
+			errCode: "ring_state",
+		},
+		{
+			statuses: []wsSendFrame{
+				{Type: datalayer.ERROR, Payload: datalayer.ActionPayload{Addr: "COM9", Message: datalayer.ErrNotOpen}},
+			},
+			code:    http.StatusNotFound,
+			errCode: "not_open",
+		},
+		{
+			statuses: []wsSendFrame{
+				{Type: datalayer.CONNECT_RING, Payload: datalayer.ActionPayload{
+					To: "9", Err: fmt.Errorf("%w: no ring member with addr 9", datalayer.ErrUnknownDest)}},
+			},
+			code:    http.StatusNotFound,
+			errCode: "unknown_dest",
+		},
+	}
+
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/ring", nil)
+		op := func(req string) {
+			for _, s := range c.statuses {
+				m := s
+				p, _ := m.Payload.(datalayer.ActionPayload)
+				p.Req = req
+				m.Payload = p
+				clients.route(&m)
+			}
+		}
+		_, code, ok := call(w, r, op, map[byte]int{datalayer.CONNECT_RING: http.StatusOK})
+		if code != c.code || ok != c.ok {
+			t.Errorf("[%d] got code %d ok %t, expected %d %t", i, code, ok, c.code, c.ok)
+		}
+		if c.ok {
+			continue
+		}
+		if w.Code != c.code {
+			t.Errorf("[%d] wrong response code %d", i, w.Code)
+		}
+		var body struct {
+			Error wsError `json:"error"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("[%d] cannot read error: %s", i, err)
+		}
+		if body.Error.Code != c.errCode {
+			t.Errorf("[%d] wrong error code %s, expected %s", i, body.Error.Code, c.errCode)
+		}
+	}
+}
+
+func TestAPINotAllowed(t *testing.T) {
+	w := httptest.NewRecorder()
+	API().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/ring", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("wrong code %d", w.Code)
+	}
+	if got := w.Header().Get("Allow"); got != "POST, DELETE" {
+		t.Errorf("wrong Allow header %s", got)
+	}
+}
@@ -0,0 +1,167 @@
+package applayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	keptEvents = 256              // for Last-Event-ID resume
+	pingPeriod = 15 * time.Second // keeps idle stream alive behind proxies
+)
+
+// sseEvent is event of the streams, its id counts responses too, unlike seq.
+type sseEvent struct {
+	id uint64
+	m  *wsSendFrame
+}
+
+// stream is SSE subscriber, it gets events of the given types.
+type stream struct {
+	types map[string]bool // by v2 event name, nil means all
+	c     chan *sseEvent
+}
+
+func (s *stream) wants(e *sseEvent) bool {
+	return s.types == nil || s.types[events[e.m.Type]]
+}
+
+// publish keeps event and passes it to the streams, stream which
+// is too slow is dropped like slow ws client.
+func (h *hub) publish(m *wsSendFrame) {
+	h.sseID++
+	e := &sseEvent{id: h.sseID, m: m}
+	h.kept = append(h.kept, e)
+	if len(h.kept) > keptEvents {
+		h.kept = h.kept[len(h.kept)-keptEvents:]
+	}
+	for s := range h.streams {
+		if !s.wants(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			log.Printf("SSE stream is too slow, drop it")
+			h.drop(s)
+		}
+	}
+}
+
+// subscribe adds the stream and returns kept events after id, so nothing is
+// lost between them and the stream.
+func (h *hub) subscribe(s *stream, after uint64) []*sseEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.streams[s] = true
+	var missed []*sseEvent
+	for _, e := range h.kept {
+		if e.id > after && s.wants(e) {
+			missed = append(missed, e)
+		}
+	}
+
+	return missed
+}
+
+// unsubscribe may be called for the dropped stream.
+func (h *hub) unsubscribe(s *stream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(s)
+}
+
+func (h *hub) drop(s *stream) {
+	if h.streams[s] {
+		delete(h.streams, s)
+		close(s.c)
+	}
+}
+
+// Events streams events as Server-Sent Events for read-only monitors: broadcast
+// ones and responses to every client, e.g. ACK, but snapshots. Event id is
+// counted by the stream, data is v2 event. Query "type" filters events by v2 names,
+// e.g. /events?type=message,ack. Last-Event-ID resumes the stream
+// from kept events.
+func Events(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r); !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	s := &stream{c: make(chan *sseEvent, queueLen)}
+	if q := r.URL.Query().Get("type"); q != "" {
+		s.types = make(map[string]bool)
+		for _, t := range strings.Split(q, ",") {
+			s.types[strings.TrimSpace(t)] = true
+		}
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId") // EventSource can't set headers on the first connect
+	}
+	var after uint64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			http.Error(w, "wrong Last-Event-ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	missed := clients.subscribe(s, after)
+	defer clients.unsubscribe(s)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range missed {
+		if !writeEvent(w, e) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-s.c:
+			if !ok {
+				return // dropped
+			}
+			if !writeEvent(w, e) {
+				return
+			}
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e *sseEvent) bool {
+	j, err := json.Marshal(toEvent(e.m))
+	if err != nil {
+		log.Printf("cannot json marshal %T", e.m)
+		return true
+	}
+	if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, events[e.m.Type], j); err != nil {
+		log.Printf("SSE stream is closed: %s", err)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,88 @@
+package applayer
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Pobeda/datalayer"
+)
+
+func TestEvents(t *testing.T) {
+	clients = newHub()
+	srv := httptest.NewServer(http.HandlerFunc(Events))
+	defer srv.Close()
+
+	for _, typ := range []byte{datalayer.MESSAGE, datalayer.QUEUE, datalayer.MESSAGE} {
+		clients.route(&wsSendFrame{Type: typ, Payload: datalayer.ActionPayload{}})
+	}
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"?type=message,disruption", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("wrong content type %s", ct)
+	}
+
+	// kept message 3 is resumed, queue is filtered out, disruption comes live
+	clients.route(&wsSendFrame{Type: datalayer.QUEUE, Payload: datalayer.ActionPayload{}})
+	clients.route(&wsSendFrame{Type: datalayer.DISRUPTION, Payload: datalayer.ActionPayload{}})
+	expected := []string{"id: 3", "event: message", "id: 5", "event: disruption"}
+	var got []string
+	sc := bufio.NewScanner(resp.Body)
+	for len(got) < len(expected) && sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "id: ") || strings.HasPrefix(line, "event: ") {
+			got = append(got, line)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestEventsResponses(t *testing.T) {
+	clients = newHub()
+	srv := httptest.NewServer(http.HandlerFunc(Events))
+	defer srv.Close()
+	c := &Client{uuid: "a", proto: protoV2, sendC: make(chan []byte, 4)}
+	clients.register(c)
+
+	resp, err := http.Get(srv.URL + "?type=message,ack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// statuses of the message sent by ws client a
+	clients.route(&wsSendFrame{Type: datalayer.PENDING, Payload: datalayer.ActionPayload{ID: 7, Req: c.reqOf("1")}})
+	clients.route(&wsSendFrame{Type: datalayer.ACK, Payload: datalayer.ActionPayload{ID: 7, Req: c.reqOf("1")}})
+	clients.route(&wsSendFrame{Type: datalayer.MESSAGE, Payload: datalayer.ActionPayload{ID: 8}})
+	expected := []string{"id: 2", "event: ack", `data: {"event":"ack","payload":{"id":7}}`, "id: 3", "event: message"}
+	var got []string
+	sc := bufio.NewScanner(resp.Body)
+	for len(got) < len(expected) && sc.Scan() {
+		if line := sc.Text(); line != "" {
+			got = append(got, line)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	if len(c.sendC) != 3 {
+		t.Errorf("owner got %d frames, expected 3", len(c.sendC))
+	}
+	for len(c.sendC) != 0 {
+		if m := string(<-c.sendC); strings.Contains(m, `"event":"ack"`) && strings.Contains(m, `"seq"`) {
+			t.Errorf("response has seq %s", m)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package applayer
+
+import (
+	"encoding/json"
+	"strings"
+
+	"Pobeda/datalayer"
+)
+
+// Protocol v2 is negotiated by Sec-WebSocket-Protocol. Ops and events have names
+// instead of overlapping numbers, request id of the client is echoed in the
+// events caused by the request and errors are objects.
+// Clients which don't ask for v2 talk v1 with numeric types.
+const (
+	protoV1 = "pobeda.v1"
+	protoV2 = "pobeda.v2"
+)
+
+// subprotocols in the order of preference
+var subprotocols = []string{protoV2, protoV1}
+
+// v2 op names
+var ops = map[string]byte{
+	"connect":      datalayer.OP_CONNECT,
+	"disconnect":   datalayer.OP_DISCONNECT,
+	"ring_connect": datalayer.OP_RING_CONNECT,
+	"kill_ring":    datalayer.OP_KILL_RING,
+	"send":         datalayer.OP_SEND,
+	"group_create": datalayer.OP_GROUP_CREATE,
+	"group_join":   datalayer.OP_GROUP_JOIN,
+	"group_leave":  datalayer.OP_GROUP_LEAVE,
+	"group_send":   datalayer.OP_GROUP_SEND,
+	"set_nick":     datalayer.OP_SET_NICK,
+	"join_ring":    datalayer.OP_JOIN_RING,
+	"leave_ring":   datalayer.OP_LEAVE_RING,
+	"auto_ring":    datalayer.OP_AUTO_RING,
+	"stats":        datalayer.OP_STATS,
+	"snapshot":     datalayer.OP_SNAPSHOT,
+}
+
+// v2 event names by status
+var events = map[byte]string{
+	datalayer.NO_ACK:             "no_ack",
+	datalayer.DISCONNECT:         "disconnect",
+	datalayer.DISRUPTION:         "disruption",
+	datalayer.CONNECT:            "connect",
+	datalayer.CONNECT_REQUEST:    "connect_request",
+	datalayer.DISCONNECT_REQUEST: "disconnect_request",
+	datalayer.ACK:                "ack",
+	datalayer.ERROR:              "error",
+	datalayer.CONNECT_RING:       "connect_ring",
+	datalayer.MESSAGE:            "message",
+	datalayer.PENDING:            "pending",
+	datalayer.TIMEOUT:            "timeout",
+	datalayer.QUEUE:              "queue",
+	datalayer.GROUP:              "group",
+	datalayer.NODE:               "node",
+	datalayer.ROSTER:             "roster",
+	datalayer.RING_CHANGE:        "ring_change",
+	datalayer.RELINK:             "relink",
+	datalayer.AUTO_RING:          "auto_ring",
+	datalayer.STATS:              "stats",
+	datalayer.PURGED:             "purged",
+	datalayer.RING_STATE:         "ring_state",
+	datalayer.SNAPSHOT:           "snapshot",
+}
+
+// v2 error codes, errors of app layer itself
+const (
+	errBadFrame  = "bad_frame"
+	errUnknownOp = "unknown_op"
+)
+
+// v2 errors by ERROR message of data layer
+var wsErrors = map[string]wsError{
+	datalayer.ErrProtocolBug: {Code: "protocol", Message: "malformed request or protocol bug"},
+	datalayer.ErrPhysConnect: {Code: "phys_connect", Message: "cannot connect to the port"},
+	datalayer.ErrRingConnect: {Code: "ring_connect", Message: "cannot connect the ring"},
+	datalayer.ErrGroup:       {Code: "group", Message: "wrong group or group op"},
+	datalayer.ErrNick:        {Code: "nick", Message: "wrong or taken nickname"},
+	datalayer.ErrRingState:   {Code: "ring_state", Message: "op is illegal in the current ring state"},
+	datalayer.ErrMessageID:   {Code: "message_id", Message: "message id is in use"},
+	datalayer.ErrNotOpen:     {Code: "not_open", Message: "port is not open"},
+}
+
+// wsRequest is v2 frame from client.
+type wsRequest struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id,omitempty"` // echoed in the events caused by the request
+	Payload json.RawMessage `json:"payload"`
+}
+
+// wsEvent is v2 frame to client.
+type wsEvent struct {
+	Event   string      `json:"event"`
+	Seq     uint64      `json:"seq,omitempty"` // see wsSendFrame
+	ID      string      `json:"id,omitempty"`  // of the client request which caused the event
+	Payload interface{} `json:"payload,omitempty"`
+	Error   *wsError    `json:"error,omitempty"`
+}
+
+type wsError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// reqOf makes request id unique among clients, ids are chosen by clients.
+// Request without id still has the owner, so the response goes to the client.
+func (c *Client) reqOf(id string) string {
+	return c.uuid + "/" + id
+}
+
+// idOf is reverse to reqOf, requests of other clients are not echoed.
+func (c *Client) idOf(req string) string {
+	prefix := c.uuid + "/"
+	if !strings.HasPrefix(req, prefix) {
+		return ""
+	}
+
+	return req[len(prefix):]
+}
+
+// eventOf converts v1 frame to v2 event for the client.
+func (c *Client) eventOf(m *wsSendFrame) *wsEvent {
+	e := toEvent(m)
+	if p, ok := m.Payload.(datalayer.ActionPayload); ok {
+		e.ID = c.idOf(p.Req)
+	}
+
+	return e
+}
+
+// toEvent converts v1 frame to v2 event which isn't an answer to anybody.
+func toEvent(m *wsSendFrame) *wsEvent {
+	e := &wsEvent{
+		Event:   events[m.Type],
+		Seq:     m.Seq,
+		Payload: m.Payload,
+	}
+	if p, ok := m.Payload.(datalayer.ActionPayload); ok && m.Type == datalayer.ERROR {
+		e.Error, e.Payload = errorOf(p), nil
+	}
+
+	return e
+}
+
+// errorOf converts ERROR status of data layer to error object.
+func errorOf(p datalayer.ActionPayload) *wsError {
+	we, ok := wsErrors[p.Message]
+	if !ok {
+		we = wsError{Code: p.Message, Message: p.Message}
+	}
+	if p.Addr != "" || p.To != "" {
+		we.Details = make(map[string]string, 2)
+		if p.Addr != "" {
+			we.Details["addr"] = p.Addr
+		}
+		if p.To != "" {
+			we.Details["to"] = p.To
+		}
+	}
+
+	return &we
+}
@@ -0,0 +1,61 @@
+package applayer
+
+import (
+	"reflect"
+	"testing"
+
+	"Pobeda/datalayer"
+)
+
+func TestEventOf(t *testing.T) {
+	c := &Client{uuid: "a", proto: protoV2}
+	cases := []struct {
+		frame    wsSendFrame
+		expected wsEvent
+	}{
+		{
+			// status of my request
+			frame: wsSendFrame{Type: datalayer.ACK, Payload: datalayer.ActionPayload{ID: 3, Req: c.reqOf("req-1")}},
+			expected: wsEvent{
+				Event:   "ack",
+				ID:      "req-1",
+				Payload: datalayer.ActionPayload{ID: 3, Req: "a/req-1"},
+			},
+		},
+		{
+			// request of another client is not echoed
+			frame: wsSendFrame{Type: datalayer.PENDING, Payload: datalayer.ActionPayload{ID: 4, Req: "b/req-1"}},
+			expected: wsEvent{
+				Event:   "pending",
+				Payload: datalayer.ActionPayload{ID: 4, Req: "b/req-1"},
+			},
+		},
+		{
+			frame: wsSendFrame{
+				Type:    datalayer.ERROR,
+				Payload: datalayer.ActionPayload{Addr: "/dev/ttyS0", Message: datalayer.ErrPhysConnect, Req: "a/2"},
+			},
+			expected: wsEvent{
+				Event: "error",
+				ID:    "2",
+				Error: &wsError{
+					Code:    "phys_connect",
+					Message: "cannot connect to the port",
+					Details: map[string]string{"addr": "/dev/ttyS0"},
+				},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		got := c.eventOf(&tc.frame)
+		if !reflect.DeepEqual(*got, tc.expected) {
+			t.Errorf("[%d] got %+v, expected %+v", i, *got, tc.expected)
+		}
+	}
+	for s := byte(0); s <= datalayer.SNAPSHOT; s++ {
+		if events[s] == "" {
+			t.Errorf("status %d has no event name", s)
+		}
+	}
+}
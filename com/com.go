@@ -6,6 +6,9 @@ import (
 	"log"
 	"path"
 	"regexp"
+	"sync"
+
+	"Pobeda/logs"
 
 	"github.com/jacobsa/go-serial/serial"
 )
@@ -19,7 +22,9 @@ var (
 	comName = "ttyS"                        // Linux com-ports
 	portNum = regexp.MustCompile("[0-9]+$") // pass ttyS0, com0 or 0
 
-	conns = make(map[string]*Port, 2)
+	// ports are opened and closed by data layer, written by the layer goroutine
+	connsMu sync.Mutex
+	conns   = make(map[string]*Port, 2)
 
 	ErrConnNotFound = errors.New("connection not found")
 )
@@ -74,16 +79,22 @@ func Connect(cfg *Config) error {
 		p:   s,
 		cfg: cfg,
 	}
+	connsMu.Lock()
 	conns[cfg.Name] = p
+	connsMu.Unlock()
 
+	L.wg.Add(1)
 	go listenPort(p)
 
 	return nil
 }
 
 func ClosePort(addr string) error {
-	if c, ok := conns[addr]; ok {
-		delete(conns, addr)
+	connsMu.Lock()
+	c, ok := conns[addr]
+	delete(conns, addr)
+	connsMu.Unlock()
+	if ok {
 		return c.p.Close()
 	} else {
 		return ErrConnNotFound
@@ -91,7 +102,10 @@ func ClosePort(addr string) error {
 }
 
 func write(addr string, b []byte) error {
-	if c, ok := conns[addr]; ok {
+	connsMu.Lock()
+	c, ok := conns[addr]
+	connsMu.Unlock()
+	if ok {
 		_, err := c.p.Write(b)
 		return err
 	} else {
@@ -99,10 +113,16 @@ func write(addr string, b []byte) error {
 	}
 }
 
+// listenPort reads the port until it is closed or the layer is stopped.
 func listenPort(s *Port) {
+	defer L.wg.Done()
 	buf := make([]byte, 128)
 	for {
 		n, err := s.p.Read(buf)
+		if !isOpen(s) {
+			log.Printf("com: port %s is closed", s.cfg.Name)
+			return
+		}
 		if err != nil {
 			// todo: error
 			if err == io.EOF {
@@ -116,15 +136,26 @@ func listenPort(s *Port) {
 			// log.Printf("alive")
 			continue
 		}
-		log.Printf("got chunk: %x", buf[:n])
+		logs.Debugf("got chunk: %x", buf[:n])
 
 		// send chunks to the data link layer
 		res := make([]byte, n)
 		copy(res, buf)
-		log.Printf("sending chunk to data link layer: %x", res)
-		L.GotC <- &SendInfo{
+		logs.Debugf("sending chunk to data link layer: %x", res)
+		select {
+		case L.GotC <- &SendInfo{
 			Name: s.cfg.Name,
 			Data: res,
+		}:
+		case <-L.ctx.Done():
+			return
 		}
 	}
 }
+
+func isOpen(s *Port) bool {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+
+	return conns[s.cfg.Name] == s
+}
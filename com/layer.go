@@ -1,31 +1,55 @@
 package com
 
 import (
+	"context"
 	"log"
+	"sync"
+
+	"Pobeda/logs"
 )
 
 var (
-	L layer
+	L *layer
 )
 
 const (
 	queueLen = 32
 )
 
+// Channels are never closed: goroutines of the layer stop by ctx,
+// so data layer may still write to SendC while shutting down.
 type layer struct {
 	SendC chan *SendInfo
 	GotC  chan *SendInfo
+
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup // port listeners and writer
 }
 
-func newLayer(len int) layer {
-	return layer{
+func newLayer(ctx context.Context, len int) *layer {
+	l := &layer{
 		SendC: make(chan *SendInfo, len),
 		GotC:  make(chan *SendInfo, len),
 	}
+	l.ctx, l.stop = context.WithCancel(ctx)
+
+	return l
 }
 
 func (l *layer) listenToDataLinkLayer() {
-	for m := range l.SendC {
+	defer l.wg.Done()
+	for {
+		var m *SendInfo
+		select {
+		case m = <-l.SendC:
+		case <-l.ctx.Done():
+			return
+		}
+		if m.flushed != nil {
+			close(m.flushed) // everything before is written
+			continue
+		}
 		if err := write(m.Name, m.Data); err != nil {
 			// blah-blah-blah
 			if err == ErrConnNotFound { // or dead
@@ -34,27 +58,57 @@ func (l *layer) listenToDataLinkLayer() {
 			log.Printf("com: cannot write to port: %s", err) // dead port, etc.
 			continue
 		}
-		log.Printf("send %x to %s", m.Data, m.Name)
+		logs.Debugf("send %x to %s", m.Data, m.Name)
 	}
 }
 
 type SendInfo struct {
 	Name string
 	Data []byte
+
+	flushed chan struct{} // marker of Drain
 }
 
-func Init() {
-	L = newLayer(queueLen)
+// Init starts the layer with channels of qlen (default if 0),
+// it works until Close or ctx is done.
+func Init(ctx context.Context, qlen int) {
+	if qlen <= 0 {
+		qlen = queueLen
+	}
+	L = newLayer(ctx, qlen)
+	L.wg.Add(1)
 	go L.listenToDataLinkLayer()
 }
 
+// Drain waits until chunks queued before the call are written to the ports.
+func Drain(ctx context.Context) error {
+	m := &SendInfo{flushed: make(chan struct{})}
+	select {
+	case L.SendC <- m:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-m.flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes ports and waits for goroutines of the layer.
 func Close() {
+	connsMu.Lock()
+	names := make([]string, 0, len(conns))
 	for c := range conns {
+		names = append(names, c)
+	}
+	connsMu.Unlock()
+	for _, c := range names {
 		if err := ClosePort(c); err != nil {
 			log.Printf("close port %s err: %s", c, err)
 		}
 	}
-
-	close(L.SendC)
-	close(L.GotC)
+	L.stop()
+	L.wg.Wait()
 }
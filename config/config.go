@@ -0,0 +1,283 @@
+// Package config is configuration of the node: JSON file and command-line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"Pobeda/com"
+)
+
+// auto ring policies
+const (
+	AutoRingOff   = "off"   // ring is connected by user
+	AutoRingElect = "elect" // nodes with both ports connected elect initiator and connect the ring
+	AutoRingJoin  = "join"  // join the working ring at startup, elect if there is no one
+)
+
+// roles of clients without token
+const (
+	AnonymousNone     = "none" // rejected
+	AnonymousObserver = "observer"
+	AnonymousOperator = "operator" // no access control
+)
+
+type Config struct {
+	Listen   string       `json:"listen"` // HTTP address
+	Ctl      string       `json:"ctl"`    // control socket, ctl.sock in the state dir if empty
+	Nick     string       `json:"nick"`
+	Ports    []com.Config `json:"ports"`    // opened at startup
+	AutoRing string       `json:"autoRing"` // off by default, join in daemon mode
+	Daemon   bool         `json:"daemon"`   // headless: no web UI
+	Timeouts Timeouts     `json:"timeouts"`
+	Queues   Queues       `json:"queues"`
+	Log      Log          `json:"log"`
+	Auth     Auth         `json:"auth"`
+}
+
+type Timeouts struct {
+	Link     Duration `json:"link"`     // ring connect, join and leave
+	Send     Duration `json:"send"`     // message ACK
+	Shutdown Duration `json:"shutdown"` // to tell the ring I'm leaving and write queued frames
+}
+
+// Queues are lengths of the channels between layers.
+type Queues struct {
+	Com  int `json:"com"`
+	Data int `json:"data"`
+	App  int `json:"app"` // of every client
+}
+
+type Log struct {
+	Level string `json:"level"` // see logs package
+	File  string `json:"file"`  // stderr if empty
+}
+
+// Auth is access of websocket, REST and SSE clients, control socket is
+// restricted by file permissions.
+type Auth struct {
+	Origins       []string `json:"origins"`       // allowed for browsers, "*" is any, same origin if empty
+	Token         string   `json:"token"`         // of operators, generated in the state dir if empty
+	ObserverToken string   `json:"observerToken"` // optional, read only
+	Anonymous     string   `json:"anonymous"`     // role of clients without token, none by default
+}
+
+// Duration is time.Duration written as "5s" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Default returns configuration of the node started without any.
+func Default() *Config {
+	return &Config{
+		Listen: ":8000",
+		Timeouts: Timeouts{
+			Link:     Duration(5 * time.Second),
+			Send:     Duration(5 * time.Second),
+			Shutdown: Duration(5 * time.Second),
+		},
+		Queues: Queues{Com: 32, Data: 32, App: 32},
+		Log:    Log{Level: "info"},
+		Auth:   Auth{Anonymous: AnonymousNone}, // operator token is always set
+	}
+}
+
+// Load reads JSON file over the defaults.
+func Load(path string) (*Config, error) {
+	c := Default()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(c); err != nil {
+		return nil, fmt.Errorf("config %s: %s", path, err)
+	}
+
+	return c, nil
+}
+
+// Parse reads -config file and applies flags over it, flags win.
+// It returns arguments after flags.
+func Parse(name string, args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	var (
+		path     = fs.String("config", "", "JSON config file")
+		listen   = fs.String("listen", "", "HTTP listen address (default :8000)")
+		ctl      = fs.String("ctl", "", "control socket (default ctl.sock in the state dir)")
+		nick     = fs.String("nick", "", "node nickname")
+		autoRing = fs.String("auto-ring", "", "auto ring policy: off, elect or join")
+		daemon   = fs.Bool("daemon", false, "headless mode: no web UI, auto ring policy is join by default")
+		logLevel = fs.String("log-level", "", "log level: debug, info or off")
+		logFile  = fs.String("log-file", "", "log file (default stderr)")
+		token    = fs.String("token", "", "operator token (default is generated in the state dir)")
+		origins  = fs.String("origins", "", "comma separated origins allowed for browsers, * is any (default same origin)")
+		anon     = fs.String("anonymous", "", "role of clients without token: none, observer or operator (default none)")
+		link     = fs.Duration("link-timeout", 0, "ring connect, join and leave timeout (default 5s)")
+		send     = fs.Duration("send-timeout", 0, "message ACK timeout (default 5s)")
+		shutdown = fs.Duration("shutdown-timeout", 0, "wait to tell the ring I'm leaving (default 5s)")
+		comQ     = fs.Int("com-queue", 0, "queue length of com layer (default 32)")
+		dataQ    = fs.Int("data-queue", 0, "queue length of data layer (default 32)")
+		appQ     = fs.Int("app-queue", 0, "queue length of every client (default 32)")
+		ports    portsFlag
+	)
+	fs.Var(&ports, "port", "port to open at startup NAME[:BAUD], may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	c := Default()
+	if *path != "" {
+		var err error
+		if c, err = Load(*path); err != nil {
+			return nil, nil, err
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			c.Listen = *listen
+		case "ctl":
+			c.Ctl = *ctl
+		case "nick":
+			c.Nick = *nick
+		case "auto-ring":
+			c.AutoRing = *autoRing
+		case "daemon":
+			c.Daemon = *daemon
+		case "log-level":
+			c.Log.Level = *logLevel
+		case "log-file":
+			c.Log.File = *logFile
+		case "port":
+			c.Ports = ports
+		case "token":
+			c.Auth.Token = *token
+		case "origins":
+			c.Auth.Origins = strings.Split(*origins, ",")
+		case "anonymous":
+			c.Auth.Anonymous = *anon
+		case "link-timeout":
+			c.Timeouts.Link = Duration(*link)
+		case "send-timeout":
+			c.Timeouts.Send = Duration(*send)
+		case "shutdown-timeout":
+			c.Timeouts.Shutdown = Duration(*shutdown)
+		case "com-queue":
+			c.Queues.Com = *comQ
+		case "data-queue":
+			c.Queues.Data = *dataQ
+		case "app-queue":
+			c.Queues.App = *appQ
+		}
+	})
+	if c.AutoRing == "" {
+		c.AutoRing = AutoRingOff
+		if c.Daemon {
+			c.AutoRing = AutoRingJoin
+		}
+	}
+	for i := range c.Ports {
+		fillPort(&c.Ports[i])
+	}
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return c, fs.Args(), nil
+}
+
+func (c *Config) Validate() error {
+	switch c.AutoRing {
+	case AutoRingOff, AutoRingElect, AutoRingJoin:
+	default:
+		return fmt.Errorf("unknown auto ring policy %s", c.AutoRing)
+	}
+	switch c.Auth.Anonymous {
+	case AnonymousNone, AnonymousObserver, AnonymousOperator:
+	default:
+		return fmt.Errorf("unknown anonymous role %s", c.Auth.Anonymous)
+	}
+	if c.Auth.Token != "" && c.Auth.Token == c.Auth.ObserverToken {
+		return errors.New("observer token is the same as operator one")
+	}
+	if c.Timeouts.Link <= 0 || c.Timeouts.Send <= 0 || c.Timeouts.Shutdown <= 0 {
+		return errors.New("timeouts must be positive")
+	}
+	if c.Queues.Com <= 0 || c.Queues.Data <= 0 || c.Queues.App <= 0 {
+		return errors.New("queue sizes must be positive")
+	}
+	for _, p := range c.Ports {
+		if p.Name == "" {
+			return errors.New("port name is empty")
+		}
+	}
+
+	return nil
+}
+
+// fillPort sets omitted serial settings to 8N1 at default baud rate.
+func fillPort(p *com.Config) {
+	if p.BaudRate == 0 {
+		p.BaudRate = com.DefaultBaudRate
+	}
+	if p.Size == 0 {
+		p.Size = 8
+	}
+	if p.Parity == "" {
+		p.Parity = "none"
+	}
+	if p.StopBits == 0 {
+		p.StopBits = 1
+	}
+}
+
+// portsFlag is -port NAME[:BAUD].
+type portsFlag []com.Config
+
+func (p *portsFlag) String() string {
+	var s []string
+	for _, c := range *p {
+		s = append(s, fmt.Sprintf("%s:%d", c.Name, c.BaudRate))
+	}
+
+	return strings.Join(s, ",")
+}
+
+func (p *portsFlag) Set(v string) error {
+	c := com.Config{Name: v}
+	if i := strings.LastIndexByte(v, ':'); i >= 0 {
+		baud, err := strconv.ParseUint(v[i+1:], 10, 32)
+		if err != nil || baud == 0 {
+			return fmt.Errorf("wrong baud rate in %s", v)
+		}
+		c.Name, c.BaudRate = v[:i], uint(baud)
+	}
+	*p = append(*p, c)
+
+	return nil
+}
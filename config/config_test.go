@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Pobeda/com"
+)
+
+func TestParse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pobeda.json")
+	err := os.WriteFile(path, []byte(`{
+		"listen": ":9000",
+		"nick": "alice",
+		"ports": [{"name": "COM1", "baudRate": 9600, "parity": "even"}],
+		"timeouts": {"link": "2s"},
+		"queues": {"data": 64}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, args, err := Parse("test", []string{"-config", path, "-nick", "bob", "-daemon", "ws://host/ws"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0] != "ws://host/ws" {
+		t.Errorf("wrong args %v", args)
+	}
+	if c.Listen != ":9000" || c.Nick != "bob" || !c.Daemon || c.AutoRing != AutoRingJoin {
+		t.Errorf("wrong config %+v", c)
+	}
+	want := com.Config{Name: "COM1", BaudRate: 9600, Size: 8, Parity: "even", StopBits: 1}
+	if len(c.Ports) != 1 || c.Ports[0] != want {
+		t.Errorf("wrong ports %+v", c.Ports)
+	}
+	if time.Duration(c.Timeouts.Link) != 2*time.Second || c.Timeouts.Send != Default().Timeouts.Send {
+		t.Errorf("wrong timeouts %+v", c.Timeouts)
+	}
+	if c.Queues.Data != 64 || c.Queues.App != Default().Queues.App {
+		t.Errorf("wrong queues %+v", c.Queues)
+	}
+
+	// flag ports replace ports of the file
+	c, _, err = Parse("test", []string{"-config", path, "-port", "COM2", "-port", "COM3:9600", "-auto-ring", "off", "-daemon",
+		"-origins", "http://a,http://b", "-send-timeout", "7s", "-app-queue", "8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Ports) != 2 || c.Ports[0].Name != "COM2" || c.Ports[0].BaudRate != com.DefaultBaudRate ||
+		c.Ports[1].Name != "COM3" || c.Ports[1].BaudRate != 9600 {
+		t.Errorf("wrong ports %+v", c.Ports)
+	}
+	if c.AutoRing != AutoRingOff {
+		t.Errorf("auto ring is %s, want off", c.AutoRing)
+	}
+	if time.Duration(c.Timeouts.Send) != 7*time.Second || time.Duration(c.Timeouts.Link) != 2*time.Second {
+		t.Errorf("flag timeouts %+v", c.Timeouts)
+	}
+	if c.Queues.App != 8 || c.Queues.Data != 64 {
+		t.Errorf("flag queues %+v", c.Queues)
+	}
+	if len(c.Auth.Origins) != 2 || c.Auth.Origins[1] != "http://b" || c.Auth.Anonymous != AnonymousNone {
+		t.Errorf("wrong auth %+v", c.Auth)
+	}
+
+	for _, args := range [][]string{
+		{"-auto-ring", "always"},
+		{"-port", "COM1:fast"},
+		{"-anonymous", "admin"},
+		{"-data-queue", "0"},
+		{"-config", filepath.Join(t.TempDir(), "none.json")},
+	} {
+		if _, _, err := Parse("test", args); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+// Package ctl is `pobeda ctl`: admin commands to the running node over its
+// control socket. The node serves REST API and SSE events of app layer on the
+// socket, so commands perform the same ops as websocket clients.
+package ctl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"Pobeda/com"
+	"Pobeda/config"
+	"Pobeda/datalayer"
+)
+
+// SocketName is the control socket in the state dir.
+const SocketName = "ctl.sock"
+
+const callMargin = 10 * time.Second // the node gives up earlier
+
+const usage = `usage: pobeda ctl [-config FILE] [-ctl PATH] [-json] COMMAND [ARGS]
+
+commands:
+  ports                            open ports
+  connect NAME [BAUD]              connect to the port
+  disconnect NAME                  disconnect from the port
+  ring up|down                     connect or kill the ring
+  send [-to DEST|-group G] TEXT    send message and wait for delivery
+  status                           snapshot of the node
+  roster                           ring members
+  tail [-type EVENTS]              print events until interrupted, e.g. -type message,ack`
+
+// apiError is error response of the node.
+type apiError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	s := e.Message
+	var kv []string
+	for k, v := range e.Details {
+		kv = append(kv, k+": "+v)
+	}
+	if len(kv) != 0 {
+		sort.Strings(kv)
+		s += " (" + strings.Join(kv, ", ") + ")"
+	}
+
+	return s
+}
+
+// event is v2 event of the SSE stream.
+type event struct {
+	Event   string                  `json:"event"`
+	Seq     uint64                  `json:"seq,omitempty"`
+	Payload datalayer.ActionPayload `json:"payload"`
+	Error   *apiError               `json:"error,omitempty"`
+}
+
+type client struct {
+	http *http.Client
+	json bool          // output for scripts
+	wait time.Duration // of the call, longer than the node waits for the result
+	out  io.Writer
+}
+
+// Run performs the command of args on the node. Its socket is found like the
+// node does: -ctl, ctl key of -config file or the default one, see SocketPath.
+// Results go to out as text or JSON with -json.
+func Run(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("pobeda ctl", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	path := fs.String("config", "", "JSON config file of the node")
+	ctl := fs.String("ctl", "", "control socket of the node")
+	asJSON := fs.Bool("json", false, "JSON output")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return usageError("no command")
+	}
+	cfg := config.Default()
+	if *path != "" {
+		var err error
+		if cfg, err = config.Load(*path); err != nil {
+			return err
+		}
+	}
+	if *ctl != "" {
+		cfg.Ctl = *ctl
+	}
+	socket := SocketPath(cfg)
+
+	c := &client{
+		http: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}},
+		json: *asJSON,
+		wait: datalayer.ResultWait(time.Duration(cfg.Timeouts.Link), time.Duration(cfg.Timeouts.Send)) + callMargin,
+		out:  out,
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "ports":
+		return c.ports(ctx, args)
+	case "connect":
+		return c.connect(ctx, args)
+	case "disconnect":
+		return c.disconnect(ctx, args)
+	case "ring":
+		return c.ring(ctx, args)
+	case "send":
+		return c.send(ctx, args)
+	case "status":
+		return c.status(ctx, args)
+	case "roster":
+		return c.roster(ctx, args)
+	case "tail":
+		return c.tail(ctx, args)
+	}
+
+	return usageError("unknown command " + cmd)
+}
+
+func usageError(msg string) error {
+	return errors.New(msg + "\n\n" + usage)
+}
+
+func (c *client) ports(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return usageError("usage: ports")
+	}
+	var ports []com.Config
+	if _, err := c.call(ctx, http.MethodGet, "/api/ports", nil, &ports); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(ports)
+	}
+	for _, p := range ports {
+		c.printf("%s %d %s\n", p.Name, p.BaudRate, frameFormat(p))
+	}
+
+	return nil
+}
+
+func (c *client) connect(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("usage: connect NAME [BAUD]")
+	}
+	cfg := com.Config{Name: args[0], BaudRate: com.DefaultBaudRate, Size: 8, Parity: "none", StopBits: 1}
+	if len(args) == 2 {
+		baud, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil || baud == 0 {
+			return usageError("wrong baud rate " + args[1])
+		}
+		cfg.BaudRate = uint(baud)
+	}
+
+	return c.do(ctx, http.MethodPost, "/api/ports", cfg, "connected to "+cfg.Name)
+}
+
+func (c *client) disconnect(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return usageError("usage: disconnect NAME")
+	}
+
+	return c.do(ctx, http.MethodDelete, "/api/ports/"+url.PathEscape(args[0]), nil, "disconnected from "+args[0])
+}
+
+func (c *client) ring(ctx context.Context, args []string) error {
+	if len(args) == 1 {
+		switch args[0] {
+		case "up":
+			return c.do(ctx, http.MethodPost, "/api/ring", nil, "ring is connected")
+		case "down":
+			return c.do(ctx, http.MethodDelete, "/api/ring", nil, "ring is killed")
+		}
+	}
+
+	return usageError("usage: ring up|down")
+}
+
+func (c *client) send(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	to := fs.String("to", "", "ring addr, node id or nickname, broadcast if empty")
+	group := fs.String("group", "", "group name")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 || *to != "" && *group != "" {
+		return usageError("usage: send [-to DEST|-group G] TEXT")
+	}
+	m := struct {
+		Addr    string `json:"addr,omitempty"`
+		Group   string `json:"group,omitempty"`
+		Message string `json:"message"`
+	}{*to, *group, strings.Join(fs.Args(), " ")}
+
+	var p datalayer.ActionPayload
+	code, err := c.call(ctx, http.MethodPost, "/api/messages", m, &p)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if err := c.printJSON(p); err != nil {
+			return err
+		}
+	} else {
+		c.printf("message %d: %s\n", p.ID, delivery(code, p))
+	}
+	if code != http.StatusOK {
+		return errors.New("message is not delivered")
+	}
+
+	return nil
+}
+
+func (c *client) status(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return usageError("usage: status")
+	}
+	s := &datalayer.Snapshot{}
+	if _, err := c.call(ctx, http.MethodGet, "/api/status", nil, s); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(s)
+	}
+	var ports []string
+	for _, p := range s.Ports {
+		if a := s.Neighbors[p.Name]; a != 0 {
+			ports = append(ports, fmt.Sprintf("%s -> %d", p.Name, a))
+		} else {
+			ports = append(ports, p.Name)
+		}
+	}
+	c.printf("id       %s\n", s.ID)
+	c.printf("state    %s\n", s.State)
+	c.printf("addr     %d\n", s.Addr)
+	c.printf("ring     %s\n", strings.Trim(fmt.Sprint(s.Ring), "[]"))
+	c.printf("ports    %s\n", strings.Join(ports, ", "))
+	c.printf("groups   %s\n", strings.Join(s.Groups, ", "))
+	c.printf("auto     %t\n", s.Auto)
+	c.printf("pending  %d\n", len(s.Pending))
+
+	return nil
+}
+
+func (c *client) roster(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return usageError("usage: roster")
+	}
+	s := &datalayer.Snapshot{}
+	if _, err := c.call(ctx, http.MethodGet, "/api/status", nil, s); err != nil {
+		return err
+	}
+	sort.Slice(s.Roster, func(i, j int) bool { return s.Roster[i].Addr < s.Roster[j].Addr })
+	if c.json {
+		return c.printJSON(s.Roster)
+	}
+	for _, n := range s.Roster {
+		nick, me := n.Nick, ""
+		if nick == "" {
+			nick = "-"
+		}
+		if n.ID == s.ID {
+			me = " (me)"
+		}
+		c.printf("%3d %s %s%s\n", n.Addr, nick, n.ID, me)
+	}
+
+	return nil
+}
+
+// tail prints broadcast events until ctx is done, with -json as they come.
+func (c *client) tail(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	types := fs.String("type", "", "comma separated event names, all if empty")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return usageError("usage: tail [-type EVENTS]")
+	}
+	path := "/events"
+	if *types != "" {
+		path += "?type=" + url.QueryEscape(*types)
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://pobeda"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot tail events: %s", resp.Status)
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		data := strings.TrimPrefix(sc.Text(), "data: ")
+		if data == sc.Text() {
+			continue // id, event name and pings
+		}
+		if c.json {
+			c.printf("%s\n", data)
+			continue
+		}
+		e := &event{}
+		if err := json.Unmarshal([]byte(data), e); err != nil {
+			return fmt.Errorf("wrong event %s: %s", data, err)
+		}
+		c.printf("%d %s%s\n", e.Seq, e.Event, summary(e))
+	}
+	if ctx.Err() != nil {
+		return nil // interrupted
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("node closed the stream")
+}
+
+// do performs command without result but the payload.
+func (c *client) do(ctx context.Context, method, path string, body interface{}, done string) error {
+	var p datalayer.ActionPayload
+	if _, err := c.call(ctx, method, path, body, &p); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(p)
+	}
+	c.printf("%s\n", done)
+
+	return nil
+}
+
+// call sends request to the node and decodes response to v, error response
+// is returned as error. Message results, even not delivered, are not errors.
+func (c *client) call(ctx context.Context, method, path string, body, v interface{}) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.wait)
+	defer cancel()
+	var rb io.Reader
+	if body != nil {
+		j, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		rb = bytes.NewReader(j)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, "http://pobeda"+path, rb)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return 0, fmt.Errorf("cannot reach the node: %s", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var e struct {
+			Error *apiError `json:"error"`
+		}
+		if json.Unmarshal(raw, &e) == nil && e.Error != nil {
+			return resp.StatusCode, e.Error
+		}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return 0, fmt.Errorf("wrong response %s: %s", resp.Status, err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (c *client) printJSON(v interface{}) error {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.printf("%s\n", j)
+
+	return nil
+}
+
+func (c *client) printf(format string, a ...interface{}) {
+	fmt.Fprintf(c.out, format, a...)
+}
+
+// frameFormat is like 8N1.
+func frameFormat(p com.Config) string {
+	parity := "N"
+	switch p.Parity {
+	case "odd":
+		parity = "O"
+	case "even":
+		parity = "E"
+	}
+
+	return fmt.Sprintf("%d%s%d", p.Size, parity, p.StopBits)
+}
+
+// delivery describes message result by HTTP code of /api/messages.
+func delivery(code int, p datalayer.ActionPayload) string {
+	s := "delivered"
+	switch code {
+	case http.StatusBadGateway:
+		s = "not acknowledged"
+	case http.StatusGatewayTimeout:
+		s = "timeout"
+	}
+	if len(p.Missed) != 0 {
+		s += fmt.Sprintf(", missed by %v", p.Missed)
+	}
+
+	return s
+}
+
+func summary(e *event) string {
+	p := e.Payload
+	if e.Error != nil {
+		return " " + e.Error.Error()
+	}
+	if e.Event == "message" {
+		from := p.Nick
+		if from == "" {
+			from = p.Addr
+		}
+		if p.Group != "" {
+			from += " #" + p.Group
+		}
+		return " " + from + ": " + p.Message
+	}
+	var f []string
+	if p.State != nil {
+		f = append(f, p.State.From+" -> "+p.State.To+" ("+p.State.Reason+")")
+	}
+	if p.Node != nil {
+		f = append(f, fmt.Sprintf("%d %s", p.Node.Addr, p.Node.Nick))
+	}
+	for _, v := range []string{p.Addr, p.To, p.Group, p.Message} {
+		if v != "" {
+			f = append(f, v)
+		}
+	}
+	if len(f) == 0 {
+		return ""
+	}
+
+	return " " + strings.Join(f, " ")
+}
@@ -0,0 +1,77 @@
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SocketName)
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen(path) // stale socket file is replaced
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path); err == nil {
+		t.Error("socket of the running node is taken")
+	}
+	// the node is found by its config, -ctl overrides it
+	cfg := filepath.Join(t.TempDir(), "pobeda.json")
+	if err := os.WriteFile(cfg, []byte(`{"ctl":"`+path+`"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"b","state":"connected","addr":2,"ring":[1,2],
+			"roster":[{"addr":2,"id":"b","nick":"bob"},{"addr":1,"id":"a"}]}`))
+	})
+	mux.HandleFunc("/api/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"id":7,"missed":[3]}`))
+	})
+	mux.HandleFunc("/api/ring", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error":{"code":"ring_state","message":"wrong ring state"}}`))
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("type") != "message" {
+			t.Errorf("wrong query %s", r.URL.RawQuery)
+		}
+		w.Write([]byte("id: 5\nevent: message\ndata: {\"event\":\"message\",\"seq\":5,\"payload\":{\"addr\":\"1\",\"nick\":\"al\",\"message\":\"hi\"}}\n\n"))
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	for _, c := range []struct {
+		args []string
+		out  string
+		err  string
+	}{
+		{[]string{"roster"}, "  1 - a\n  2 bob b (me)\n", ""},
+		{[]string{"-json", "roster"}, `"nick": "bob"`, ""},
+		{[]string{"status"}, "ring     1 2\n", ""},
+		{[]string{"send", "-to", "3", "hello", "there"}, "message 7: not acknowledged, missed by [3]\n", "message is not delivered"},
+		{[]string{"ring", "up"}, "", "wrong ring state"},
+		{[]string{"ring", "sideways"}, "", "usage: ring up|down"},
+		{[]string{"tail", "-type", "message"}, "5 message al: hi\n", "node closed the stream"},
+		{[]string{"-ctl", path + ".none", "status"}, "", "cannot reach the node"},
+	} {
+		out := &bytes.Buffer{}
+		err := Run(context.Background(), append([]string{"-config", cfg}, c.args...), out)
+		if !strings.Contains(out.String(), c.out) {
+			t.Errorf("%v: output is %q, want %q", c.args, out, c.out)
+		}
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%v: error is %v, want %q", c.args, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package ctl
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+
+	"Pobeda/config"
+	"Pobeda/datalayer"
+)
+
+// SocketPath is the control socket of the node with cfg: its ctl key
+// or SocketName in the state dir.
+func SocketPath(cfg *config.Config) string {
+	if cfg.Ctl != "" {
+		return cfg.Ctl
+	}
+
+	return filepath.Join(datalayer.DefaultStateDir(), SocketName)
+}
+
+// Listen opens the control socket at path, only the owner may connect.
+// Socket left by a crashed node is removed, socket of the running node is an error.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, errors.New("another node listens on " + path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
@@ -22,6 +22,20 @@ const (
 	CONNECT_RING              // connected ring
 
 	MESSAGE // message to frontend
+	PENDING // message is accepted and being sent
+	TIMEOUT // message was sent, but no ACK came in time
+	QUEUE   // outgoing messages backlog changed
+	GROUP   // group members changed
+	NODE    // ring member joined or changed its info
+	ROSTER  // all ring members
+
+	RING_CHANGE // node joined or left the ring, ring is not disrupted
+	RELINK      // neighbor has left, port is to be reconnected to another node
+	AUTO_RING   // auto ring mode is switched
+	STATS       // diagnostics counters
+	PURGED      // orphaned frame is purged, ID and src addr of the frame
+	RING_STATE  // ring state machine transition
+	SNAPSHOT    // everything about the node, events after it are incremental
 )
 
 // for ERROR
@@ -29,6 +43,11 @@ const (
 	ErrProtocolBug = "ErrProtocolBug"
 	ErrPhysConnect = "ErrPhysConnect"
 	ErrRingConnect = "ErrRingConnect"
+	ErrGroup       = "ErrGroup"
+	ErrNick        = "ErrNick"
+	ErrRingState   = "ErrRingState" // op is illegal in the current ring state
+	ErrMessageID   = "ErrMessageID" // id of the message is queued or in flight
+	ErrNotOpen     = "ErrNotOpen"   // port to disconnect from is not open
 )
 
 // system operations to perform from app layer to data layer
@@ -38,16 +57,52 @@ const (
 	OP_RING_CONNECT        // logical
 	OP_KILL_RING           // logical
 	OP_SEND                // message
+
+	OP_GROUP_CREATE // multicast
+	OP_GROUP_JOIN   // multicast
+	OP_GROUP_LEAVE  // multicast
+	OP_GROUP_SEND   // message to group
+	OP_SET_NICK     // my nickname
+	OP_JOIN_RING    // logical, insert node into the working ring
+	OP_LEAVE_RING   // logical, leave without ring disruption
+	OP_AUTO_RING    // logical, switch auto ring mode
+	OP_STATS        // diagnostics
+	OP_SNAPSHOT     // ask for SNAPSHOT
 )
 
 type SystemAction struct { // from frontend
-	Addr    string      `json:"addr"` // disconnect
+	ID      uint16      `json:"id"`   // send, 0 means data layer assigns it
+	Addr    string      `json:"addr"` // disconnect: port name, send: ring addr, node id or nickname
 	Cfg     *com.Config `json:"cfg"`  // connect
 	Message string      `json:"message"`
+	Group   string      `json:"group"` // group ops and send to group
+	Auto    bool        `json:"auto"`  // auto ring mode
+	Req     string      `json:"-"`     // request id of app layer, echoed in statuses
 }
 
 type ActionPayload struct {
+	Req     string `json:"-"`            // request which caused the status, see SystemAction
+	Err     error  `json:"-"`            // why NO_ACK, e.g. ErrUnknownDest, Message is its text
+	ID      uint16 `json:"id,omitempty"` // message id for PENDING, ACK, NO_ACK, TIMEOUT and MESSAGE
 	Addr    string `json:"addr,omitempty"`
 	Message string `json:"message,omitempty"`
 	To      string `json:"to,omitempty"`
+	ToPeer  string `json:"toPeer,omitempty"` // stable id of the destination of my message, if it's known
+	Nick    string `json:"nick,omitempty"`   // sender of MESSAGE
+	Peer    string `json:"peer,omitempty"`   // stable id of MESSAGE sender
+	Group   string `json:"group,omitempty"`  // for GROUP and group MESSAGE
+	Members []int  `json:"members,omitempty"`
+
+	Node   *NodeInfo    `json:"node,omitempty"`   // for NODE
+	Roster []NodeInfo   `json:"roster,omitempty"` // for ROSTER
+	Stats  *Stats       `json:"stats,omitempty"`  // for STATS
+	State  *StateChange `json:"state,omitempty"`  // for RING_STATE
+
+	Snapshot *Snapshot `json:"snapshot,omitempty"` // for SNAPSHOT
+
+	// for broadcast ACK, NO_ACK and TIMEOUT: addrs who got the message and who missed it
+	Delivered []int `json:"delivered,omitempty"`
+	Missed    []int `json:"missed,omitempty"`
+
+	Queue *QueueStatus `json:"queue,omitempty"` // for QUEUE
 }
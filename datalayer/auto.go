@@ -0,0 +1,68 @@
+package datalayer
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+	autoInterval = 3 * time.Second // election period in auto mode
+	autoJitter   = 2 * time.Second // nodes don't start at the same time
+)
+
+// autoRing elects ring connect initiator in auto mode. Every node with both
+// ports connected sends its stable id round the ring, nodes drop ids greater
+// than their own, so only the least id comes back and its node connects the ring.
+// Failed ring connect is retried on the next election.
+func (l *layer) autoRing(ctx context.Context) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		select {
+		case <-time.After(autoInterval + time.Duration(rnd.Int63n(int64(autoJitter)))):
+			l.post(l.elect)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// elect sends my id round the ring, if I'm a candidate.
+func (l *layer) elect() {
+	if !l.auto || l.getState() != StateIdle || len(l.conns) != 2 {
+		return
+	}
+	f, err := l.newFrame(broadcast, 0, electFrame, []byte(l.info.ID))
+	if err != nil {
+		log.Printf("cannot create elect frame: %s", err)
+		return
+	}
+	l.sendToPort(l.getRandomPortName(), f.Marshal())
+}
+
+// gotElect passes the candidate further or starts ring connect if I'm elected.
+func (l *layer) gotElect(f *frame, from string) {
+	if l.getState() != StateIdle {
+		// ring is connected or is being connected
+		return
+	}
+	id := string(f.data)
+	if l.auto {
+		if id == l.info.ID {
+			log.Printf("elected as ring connect initiator")
+			l.ringConnect()
+			return
+		}
+		if id > l.info.ID {
+			// I'm a better candidate
+			return
+		}
+	}
+	port := l.getAnotherPort(from)
+	if port == "" {
+		log.Printf("elect frame: cannot find another port")
+		return
+	}
+	l.forward(port, f)
+}
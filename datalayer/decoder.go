@@ -0,0 +1,49 @@
+package datalayer
+
+// decoder reassembles frames from the chunks read from one port.
+// Chunks may contain a part of a frame or several frames at once,
+// so frame boundaries are found by the len field, not by the stop byte
+// (data may contain it).
+type decoder struct {
+	buf []byte
+}
+
+// write appends chunk read from the port.
+func (d *decoder) write(chunk []byte) {
+	d.buf = append(d.buf, chunk...)
+}
+
+// next returns the next complete frame. It returns nil frame and nil error
+// when more data is needed. On error the broken bytes are dropped,
+// so next can be called again.
+func (d *decoder) next() (*frame, error) {
+	if len(d.buf) == 0 {
+		return nil, nil
+	}
+	if !hasStartByte(d.buf) {
+		// garbage between frames, skip up to the next possible frame
+		i := 1
+		for i < len(d.buf) && d.buf[i] != startByte {
+			i++
+		}
+		d.buf = d.buf[i:]
+		return nil, ErrBadStart
+	}
+	if len(d.buf) < headerLen {
+		return nil, nil
+	}
+	size := frameSize(d.buf)
+	if len(d.buf) < size {
+		return nil, nil
+	}
+
+	f := &frame{}
+	if err := f.Unmarshal(d.buf[:size]); err != nil {
+		// start byte may be a stop byte of the lost frame, resync from the next byte
+		d.buf = d.buf[1:]
+		return nil, err
+	}
+	d.buf = d.buf[size:]
+
+	return f, nil
+}
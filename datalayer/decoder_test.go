@@ -0,0 +1,105 @@
+package datalayer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	cases := []struct {
+		chunks   [][]byte
+		expected []frame
+		errs     int
+	}{
+		{
+			// frame split into chunks
+			chunks: [][]byte{seeds[7], seeds[8]},
+			expected: []frame{
+				{start: startByte, dest: 2, src: 1, fType: iFrame, session: 0x4e21, id: 1, hops: 4, len: 5, data: []byte("hello"), stop: stopByte},
+			},
+		},
+		{
+			// several frames in one chunk
+			chunks: [][]byte{append(append([]byte{}, seeds[2]...), seeds[6]...)},
+			expected: []frame{
+				{start: startByte, dest: broadcast, src: 1, fType: linkOKFrame, session: 0x4e21, hops: 4, stop: stopByte},
+				{start: startByte, dest: 0, src: 2, fType: uplinkFrame, session: 0x4e21, hops: 4, stop: stopByte},
+			},
+		},
+		{
+			// stop byte inside data
+			chunks: [][]byte{{startByte, 2, 1, iFrame, 0, 0, 0, 0, 0, 2, stopByte, 'a', stopByte}},
+			expected: []frame{
+				{start: startByte, dest: 2, src: 1, fType: iFrame, len: 2, data: []byte{stopByte, 'a'}, stop: stopByte},
+			},
+		},
+		{
+			chunks: [][]byte{seeds[10]},
+			expected: []frame{
+				{start: startByte, dest: broadcast, src: 1, fType: linkOKFrame, session: 0x4e21, hops: 4, stop: stopByte},
+			},
+			errs: 1,
+		},
+	}
+
+	for i, c := range cases {
+		var (
+			d    decoder
+			got  []frame
+			errs int
+		)
+		for _, chunk := range c.chunks {
+			d.write(chunk)
+			for {
+				f, err := d.next()
+				if err != nil {
+					errs++
+					continue
+				}
+				if f == nil {
+					break
+				}
+				got = append(got, *f)
+			}
+		}
+		if errs != c.errs {
+			t.Errorf("[%d] wrong number of errors: got %d, expected %d", i, errs, c.errs)
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("[%d] frames don't match: got %+v, expected %+v", i, got, c.expected)
+		}
+	}
+}
+
+func FuzzDecoder(f *testing.F) {
+	for _, c := range seeds {
+		f.Add(c, byte(3))
+	}
+	f.Fuzz(func(t *testing.T, data []byte, chunkLen byte) {
+		if chunkLen == 0 {
+			chunkLen = 1
+		}
+		var d decoder
+		for len(data) > 0 {
+			n := int(chunkLen)
+			if n > len(data) {
+				n = len(data)
+			}
+			d.write(data[:n])
+			data = data[n:]
+
+			for {
+				fr, err := d.next()
+				if err != nil {
+					continue
+				}
+				if fr == nil {
+					break
+				}
+				if int(fr.len) != len(fr.data) {
+					t.Errorf("len field %d doesn't match data len %d", fr.len, len(fr.data))
+				}
+			}
+		}
+	})
+}
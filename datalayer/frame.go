@@ -1,10 +1,8 @@
 package datalayer
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"log"
 )
 
 const (
@@ -12,51 +10,70 @@ const (
 	stopByte  byte = 0xFF
 
 	maxDataLen       = 1<<8 - 1 // 255 bytes, because len field is byte
-	minFrameLen      = 5        // 5 bytes
+	headerLen        = 10       // start, dest, src, fType, session (2 bytes), id (2 bytes), hops, len
+	minFrameLen      = 11       // header and stop byte, no data
+	maxHops          = 0xFF     // hop limit while the ring size is unknown
 	minAddr     byte = 0x01
 	maxAddr     byte = 0x7E
 	broadcast   byte = 0x7F
 )
 
 var (
-	ErrWrongFrame   = errors.New("frame is wrong")
 	ErrDataTooLarge = fmt.Errorf("data len exceeds %d bytes", maxDataLen)
+
+	// frame parsing errors
+	ErrFrameTruncated = errors.New("frame is truncated")
+	ErrBadStart       = errors.New("frame has no start byte")
+	ErrBadStop        = errors.New("frame has no stop byte")
+	ErrLenMismatch    = errors.New("frame len field doesn't match frame size")
 )
 
 // fTypes
 const (
-	iFrame      = iota // data frame
-	linkFrame          // init ring
-	linkOKFrame        // broadcast it after successful init of ring
-	uplinkFrame        // kill ring
-	ackFrame           // got frame is ok, send ok
-	retFrame           // got frame is not ok, ask for this frame again
+	iFrame        = iota // data frame
+	linkFrame            // init ring
+	linkOKFrame          // broadcast it after successful init of ring
+	uplinkFrame          // kill ring
+	ackFrame             // got frame is ok, send ok
+	retFrame             // got frame is not ok, ask for this frame again
+	groupFrame           // join or leave multicast group
+	groupMsgFrame        // message to multicast group, passes the whole ring
+	announceFrame        // info about src for the roster
+	joinFrame            // node asks neighbor to join the ring
+	memberFrame          // node has joined or is leaving the ring
+	electFrame           // candidate for ring connect initiator in auto mode
 )
 
 type frame struct {
-	start byte
-	dest  byte
-	src   byte
-	fType byte
-	len   byte   // optional
-	data  []byte // optional
-	stop  byte
+	start   byte
+	dest    byte
+	src     byte
+	fType   byte
+	session uint16 // ring session, frames of the previous rings are dropped
+	id      uint16 // message id, ackFrame echoes id of the delivered iFrame
+	hops    byte   // forwards left, frame is purged at zero
+	len     byte   // optional
+	data    []byte // optional
+	stop    byte
 }
 
-func newFrame(dest, src, fType byte, data []byte) (*frame, error) {
+// newFrame creates frame of the current ring session.
+func (l *layer) newFrame(dest, src, fType byte, data []byte) (*frame, error) {
 	if len(data) > maxDataLen {
 		return nil, ErrDataTooLarge
 	}
 	d := make([]byte, len(data))
 	copy(d, data)
 	f := &frame{
-		start: startByte,
-		dest:  dest,
-		src:   src,
-		fType: fType,
-		len:   byte(len(data)),
-		data:  d,
-		stop:  stopByte,
+		start:   startByte,
+		dest:    dest,
+		src:     src,
+		fType:   fType,
+		session: l.session,
+		hops:    l.hopLimit(),
+		len:     byte(len(data)),
+		data:    d,
+		stop:    stopByte,
 	}
 
 	return f, nil
@@ -64,43 +81,57 @@ func newFrame(dest, src, fType byte, data []byte) (*frame, error) {
 
 func (f *frame) Marshal() []byte {
 	var b []byte
-	b = append(b, f.start, f.dest, f.src, f.fType, f.len)
+	b = append(b, f.start, f.dest, f.src, f.fType, byte(f.session>>8), byte(f.session), byte(f.id>>8), byte(f.id), f.hops, f.len)
 	b = append(b, f.data...)
 	b = append(b, f.stop)
 
 	return b
 }
 
+// Unmarshal parses a single frame. It never panics: any malformed input
+// results in one of the frame parsing errors.
 func (f *frame) Unmarshal(v []byte) error {
-	if len(v) < minFrameLen || v[0] != startByte || v[len(v)-1] != stopByte {
-		return ErrWrongFrame
+	if len(v) < minFrameLen {
+		return ErrFrameTruncated
+	}
+	if !hasStartByte(v) {
+		return ErrBadStart
+	}
+	size := frameSize(v)
+	if len(v) < size {
+		return ErrFrameTruncated
+	}
+	if v[size-1] != stopByte {
+		return ErrBadStop
+	}
+	if len(v) != size {
+		return ErrLenMismatch
 	}
+
 	f.start = v[0]
 	f.dest = v[1]
 	f.src = v[2]
 	f.fType = v[3]
-	f.len = v[4]
+	f.session = uint16(v[4])<<8 | uint16(v[5])
+	f.id = uint16(v[6])<<8 | uint16(v[7])
+	f.hops = v[8]
+	f.len = v[9]
+	f.data = nil
 	if f.len != 0 {
-		f.data = v[5 : 5+f.len]
-	}
-	f.stop = v[5+f.len]
-
-	if int(5+f.len+1) != len(v) {
-		log.Printf("incorrect read of frame")
-		return ErrWrongFrame
+		f.data = make([]byte, f.len)
+		copy(f.data, v[headerLen:size-1])
 	}
+	f.stop = v[size-1]
 
 	return nil
 }
 
-func isValidFrame(f []byte) bool {
-	return f[0] == startByte && f[len(f)-1] == stopByte
+// frameSize returns full size of the frame according to its len field,
+// v must contain the whole header.
+func frameSize(v []byte) int {
+	return headerLen + int(v[headerLen-1]) + 1
 }
 
 func hasStartByte(f []byte) bool {
-	return f[0] == startByte
-}
-
-func findEndOfFrame(d []byte) int {
-	return bytes.Index(d, []byte{stopByte})
+	return len(f) > 0 && f[0] == startByte
 }
@@ -17,11 +17,13 @@ func TestFrame_Marshal(t *testing.T) {
 				dest:  0,
 				src:   1,
 				fType: iFrame,
+				id:    0x0102,
+				hops:  3,
 				len:   6,
 				data:  []byte("abcdef"),
 				stop:  stopByte,
 			},
-			expected: []byte{startByte, 0, 1, iFrame, 6, 'a', 'b', 'c', 'd', 'e', 'f', stopByte},
+			expected: []byte{startByte, 0, 1, iFrame, 0, 0, 1, 2, 3, 6, 'a', 'b', 'c', 'd', 'e', 'f', stopByte},
 		},
 		{
 			data: frame{
@@ -33,7 +35,7 @@ func TestFrame_Marshal(t *testing.T) {
 				data:  []byte(`{"nick":"asdf"}`),
 				stop:  stopByte,
 			},
-			expected: []byte{startByte, 0, 1, iFrame, 16,
+			expected: []byte{startByte, 0, 1, iFrame, 0, 0, 0, 0, 0, 16,
 				'{', '"', 'n', 'i', 'c', 'k', '"', ':', '"', 'a', 's', 'd', 'f', '"', '}', stopByte},
 		},
 	}
@@ -53,18 +55,41 @@ func TestFrame_Unmarshal(t *testing.T) {
 	}{
 		{
 			// no error
-			data: []byte{startByte, 0, 1, iFrame, 6, 'a', 'b', 'c', 'd', 'e', 'f', stopByte},
+			data: []byte{startByte, 0, 1, iFrame, 0, 0, 0, 7, 5, 6, 'a', 'b', 'c', 'd', 'e', 'f', stopByte},
 			expectedFrame: frame{
 				start: startByte,
 				dest:  0,
 				src:   1,
 				fType: iFrame,
+				id:    7,
+				hops:  5,
 				len:   6,
 				data:  []byte("abcdef"),
 				stop:  stopByte,
 			},
 			expectedErr: nil,
 		},
+		{
+			data:        []byte{startByte, 0, 1},
+			expectedErr: ErrFrameTruncated,
+		},
+		{
+			// len says 200 bytes of data
+			data:        []byte{startByte, 0, 1, iFrame, 0, 0, 0, 0, 0, 200, 'a', stopByte},
+			expectedErr: ErrFrameTruncated,
+		},
+		{
+			data:        []byte{0, 0, 1, iFrame, 0, 0, 0, 0, 0, 1, 'a', stopByte},
+			expectedErr: ErrBadStart,
+		},
+		{
+			data:        []byte{startByte, 0, 1, iFrame, 0, 0, 0, 0, 0, 1, 'a', 'b'},
+			expectedErr: ErrBadStop,
+		},
+		{
+			data:        []byte{startByte, 0, 1, iFrame, 0, 0, 0, 0, 0, 1, 'a', stopByte, stopByte},
+			expectedErr: ErrLenMismatch,
+		},
 	}
 
 	for i, c := range cases {
@@ -77,3 +102,18 @@ func TestFrame_Unmarshal(t *testing.T) {
 		}
 	}
 }
+
+func FuzzFrame_Unmarshal(f *testing.F) {
+	for _, c := range seeds {
+		f.Add(c)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var fr frame
+		if err := fr.Unmarshal(data); err != nil {
+			return
+		}
+		if got := fr.Marshal(); !reflect.DeepEqual(got, data) {
+			t.Errorf("marshal of parsed frame doesn't match input: got %x, input %x", got, data)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package datalayer
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+)
+
+const maxGroupLen = 32 // group name bytes
+
+// group change ops in groupFrame
+const (
+	groupJoin  = "join"
+	groupLeave = "leave"
+)
+
+var (
+	ErrGroupName = errors.New("wrong group name")
+)
+
+// groupChange is data of groupFrame, the member is src of the frame.
+type groupChange struct {
+	Op    string `json:"op"`
+	Group string `json:"group"`
+}
+
+// groups keeps members of multicast groups known in the ring.
+// Addrs are given by ring connect, so members are forgotten with the ring,
+// but groups I've joined are announced again in the new ring.
+type groups struct {
+	members map[string]map[byte]bool // by group name
+	mine    map[string]bool
+}
+
+func newGroups() groups {
+	return groups{
+		members: make(map[string]map[byte]bool),
+		mine:    make(map[string]bool),
+	}
+}
+
+func validGroupName(name string) bool {
+	return name != "" && len(name) <= maxGroupLen
+}
+
+func (g *groups) exists(name string) bool {
+	return len(g.members[name]) != 0
+}
+
+func (g *groups) isMember(name string, addr byte) bool {
+	return g.members[name][addr]
+}
+
+func (g *groups) join(name string, addr byte) {
+	m, ok := g.members[name]
+	if !ok {
+		m = make(map[byte]bool, 2)
+		g.members[name] = m
+	}
+	m[addr] = true
+}
+
+func (g *groups) leave(name string, addr byte) {
+	delete(g.members[name], addr)
+	if len(g.members[name]) == 0 {
+		delete(g.members, name) // nobody left, group is gone
+	}
+}
+
+// removeAddr forgets the node which has left the ring.
+func (g *groups) removeAddr(addr byte) {
+	for name := range g.members {
+		g.leave(name, addr)
+	}
+}
+
+// list returns members of the group in ascending order.
+func (g *groups) list(name string) []int {
+	var l []int
+	for a := range g.members[name] {
+		l = append(l, int(a))
+	}
+	sort.Ints(l)
+
+	return l
+}
+
+// reset forgets members of the ring which is gone.
+func (g *groups) reset() {
+	g.members = make(map[string]map[byte]bool)
+}
+
+// apply changes group membership of addr by group frame data.
+func (g *groups) apply(addr byte, data []byte) (string, error) {
+	var gc groupChange
+	if err := json.Unmarshal(data, &gc); err != nil {
+		return "", err
+	}
+	if !validGroupName(gc.Group) {
+		return "", ErrGroupName
+	}
+	switch gc.Op {
+	case groupJoin:
+		g.join(gc.Group, addr)
+	case groupLeave:
+		g.leave(gc.Group, addr)
+	default:
+		return "", errors.New("unknown group op " + gc.Op)
+	}
+
+	return gc.Group, nil
+}
+
+// groupMessageData puts group name in front of the message.
+func groupMessageData(name, message string) []byte {
+	data := make([]byte, 0, 1+len(name)+len(message))
+	data = append(data, byte(len(name)))
+	data = append(data, name...)
+	data = append(data, message...)
+
+	return data
+}
+
+// parseGroupMessage is reverse to groupMessageData.
+func parseGroupMessage(data []byte) (name, message string, ok bool) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return "", "", false
+	}
+	n := 1 + int(data[0])
+
+	return string(data[1:n]), string(data[n:]), true
+}
+
+// announceGroup informs the ring that I've joined or left the group.
+func (l *layer) announceGroup(op, name string) {
+	if l.myAddr == 0 {
+		// no ring, will be announced after ring connect
+		return
+	}
+	data, err := json.Marshal(groupChange{Op: op, Group: name})
+	if err != nil {
+		log.Printf("abnormal: cannot marshal group change: %s", err)
+		return
+	}
+	f, err := l.newFrame(broadcast, l.myAddr, groupFrame, data)
+	if err != nil {
+		log.Printf("cannot create group frame: %s", err)
+		return
+	}
+	port := l.nextPort()
+	if port == "" {
+		log.Printf("cannot announce group %s: no port available", name)
+		return
+	}
+	l.sendToPort(port, f.Marshal())
+}
+
+// announceGroups joins groups of mine in the new ring.
+func (l *layer) announceGroups() {
+	for name := range l.groups.mine {
+		l.groups.join(name, l.myAddr)
+		l.announceGroup(groupJoin, name)
+		l.SendGroupStatusToApp(name, l.groups.list(name))
+	}
+}
+
+// groupControl performs OP_GROUP_CREATE, OP_GROUP_JOIN and OP_GROUP_LEAVE.
+func (l *layer) groupControl(op byte, name string) {
+	if !validGroupName(name) {
+		log.Printf("group op %d: wrong group name '%s'", op, name)
+		l.SendActionStatusToApp(ERROR, "", name, ErrGroup)
+		return
+	}
+	switch op {
+	case OP_GROUP_CREATE:
+		if l.groups.exists(name) || l.groups.mine[name] {
+			log.Printf("cannot create group %s: already exists", name)
+			l.SendActionStatusToApp(ERROR, "", name, ErrGroup)
+			return
+		}
+		fallthrough
+	case OP_GROUP_JOIN:
+		l.groups.mine[name] = true
+		if l.myAddr != 0 {
+			l.groups.join(name, l.myAddr)
+		}
+		l.announceGroup(groupJoin, name)
+	case OP_GROUP_LEAVE:
+		if !l.groups.mine[name] {
+			log.Printf("cannot leave group %s: not a member", name)
+			l.SendActionStatusToApp(ERROR, "", name, ErrGroup)
+			return
+		}
+		delete(l.groups.mine, name)
+		l.groups.leave(name, l.myAddr)
+		l.announceGroup(groupLeave, name)
+	}
+	l.SendGroupStatusToApp(name, l.groups.list(name))
+}
@@ -0,0 +1,40 @@
+package datalayer
+
+import (
+	"log"
+	"strconv"
+	"sync/atomic"
+)
+
+// hopLimit is enough for any frame to go round the ring twice,
+// e.g. broadcast in the ring which has grown by a join on the way.
+func (l *layer) hopLimit() byte {
+	n := 2*len(l.ring) + 2
+	if len(l.ring) == 0 || n > int(maxHops) {
+		return maxHops
+	}
+
+	return byte(n)
+}
+
+// forward passes the frame of somebody else to the next node. The frame is purged
+// when its hops are over: its src has left the ring or changed its addr,
+// so nobody stops the frame.
+func (l *layer) forward(port string, f *frame) {
+	if f.hops == 0 {
+		l.purge(f)
+		return
+	}
+	f.hops--
+	l.sendToPort(port, f.Marshal())
+}
+
+// purge drops orphaned frame and tells monitoring clients about it.
+func (l *layer) purge(f *frame) {
+	atomic.AddUint64(&l.stats.PurgedFrames, 1)
+	log.Printf("purge frame which is out of hops: %+v", f)
+	l.toApp(PURGED, ActionPayload{
+		ID:   f.id,
+		Addr: strconv.Itoa(int(f.src)),
+	})
+}
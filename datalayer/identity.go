@@ -0,0 +1,52 @@
+package datalayer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/satori/go.uuid"
+)
+
+const identityFile = "identity"
+
+// DefaultStateDir is $POBEDA_STATE_DIR or ~/.pobeda.
+func DefaultStateDir() string {
+	if dir := os.Getenv("POBEDA_STATE_DIR"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".pobeda"
+	}
+
+	return filepath.Join(home, ".pobeda")
+}
+
+// LoadIdentity reads stable node id from the state dir. The id is generated
+// and saved on the first start, ring addrs change, but the id stays.
+func LoadIdentity(dir string) (string, error) {
+	path := filepath.Join(dir, identityFile)
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		id, err := uuid.FromString(strings.TrimSpace(string(b)))
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	id := uuid.NewV4().String()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, []byte(id+"\n"), 0600); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
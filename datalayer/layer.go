@@ -1,352 +1,398 @@
 package datalayer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"Pobeda/com"
+	"Pobeda/logs"
 )
 
 const (
 	queueLen = 32
 	linkWait = 5 * time.Second
 	sendWait = 5 * time.Second
+
+	maxRebroadcasts = 2 // for ring members who missed broadcast message
 )
 
 var (
-	L layer
-
-	lastFrame []byte
+	L *layer
 )
 
+// layer is owned by its event loop (see run): fields are read and written
+// only by the loop goroutine, other goroutines send actions to SendAppC or post
+// funcs to the loop. Exceptions are guarded: ring state and roster are read
+// by app layer, stats are atomic.
 type layer struct {
 	SendAppC chan *Action
 	GetAppC  chan *Action
 	QueueLen int
 
-	myAddr    byte
-	tempAddr  byte
-	conns     map[string]byte
-	lastDead  string // for messages from another peer to another peer (1 -> me ...dc... 3)
-	connected chan struct{}
-	delivered chan struct{}
+	tr       transport
+	events   chan func() // posted to the loop
+	done     chan struct{}
+	stop     context.CancelFunc
+	myAddr   byte
+	st       ringState
+	linkWait time.Duration // ring connect, join and leave
+	sendWait time.Duration // message ACK
+	conns    map[string]byte
+	ports    map[string]*com.Config // configs of open ports
+	lastDead string                 // for messages from another peer to another peer (1 -> me ...dc... 3)
+	lostPort string                 // neighbor port of the degraded ring, see degrade
+	lostAddr byte                   // neighbor behind lostPort
+	ring     []byte                 // addrs of ring members in the order of link frame pass
+	groups   groups
+	info     NodeInfo // about me for ring members
+	roster   *roster
+	auto     bool // elect initiator and connect ring without user
+	joining  bool // I'm being inserted into the working ring
+
+	linkPort  string                  // initiator sent link frame to it
+	pending   map[uint16]*pendingLink // passed link frames by session, wait for link ok
+	waitID    int                     // ring op in progress, see expect
+	waitTimer *time.Timer
+	lastFrame []byte              // for retFrame
+	history   []ActionPayload     // last messages for snapshot
+	decoders  map[string]*decoder // every port has its own stream
+
+	req         string // app layer request being handled, echoed in statuses
+	waitReq     string // request of ring op in progress
+	session     uint16 // id of the ring instance
+	linkSession uint16 // id of the ring which is being connected
+	stats       Stats
+	out         *outbox
+}
+
+// pendingLink is link frame passed by me, addrs are given when link ok comes.
+type pendingLink struct {
+	from string // port link frame came from
+	to   string // port link frame was passed to
+	addr byte   // addr of the previous node
 }
 
-func newLayer(len int) layer {
-	return layer{
+func newLayer(len int, id string, tr transport) *layer {
+	l := &layer{
 		SendAppC: make(chan *Action, len),
 		GetAppC:  make(chan *Action, len),
 		QueueLen: len,
 
-		myAddr:    0,
-		tempAddr:  0,
-		conns:     make(map[string]byte, 2),
-		connected: make(chan struct{}),
-		delivered: make(chan struct{}),
+		tr:       tr,
+		linkWait: linkWait,
+		sendWait: sendWait,
+		events:   make(chan func(), len),
+		done:     make(chan struct{}),
+		myAddr:   0,
+		conns:    make(map[string]byte, 2),
+		ports:    make(map[string]*com.Config, 2),
+		groups:   newGroups(),
+		info:     localInfo(id),
+		roster:   newRoster(),
+		pending:  make(map[uint16]*pendingLink),
+
+		decoders: make(map[string]*decoder, 2),
 	}
+	l.out = newOutbox(l)
+
+	return l
 }
 
-func (l *layer) listenToAppLayer() {
-	for a := range l.SendAppC {
-		sa, ok := a.Data.(SystemAction)
-		if !ok {
-			log.Printf("cannot cast to SystemAction '%T'", sa)
-			continue
-		}
+// handleAction performs action from app layer.
+func (l *layer) handleAction(a *Action) {
+	sa, ok := a.Data.(SystemAction)
+	if !ok {
+		log.Printf("cannot cast to SystemAction '%T'", sa)
+		return
+	}
 
-		switch a.AType {
-		case OP_CONNECT:
-			if _, ok := L.conns[sa.Cfg.Name]; ok {
-				log.Printf("cannot connect to %s: already connected", sa.Cfg.Name)
-				SendActionStatusToApp(ERROR, sa.Cfg.Name, "", ErrPhysConnect)
-				continue
-			}
-			if sa.Cfg == nil {
-				log.Printf("cannot connect: no cfg available")
-				SendActionStatusToApp(ERROR, "", "", ErrProtocolBug)
-				continue
-			}
-			if err := com.Connect(sa.Cfg); err != nil {
-				log.Printf("cannot connect to %s: %s", sa.Cfg.Name, err)
-				SendActionStatusToApp(ERROR, sa.Cfg.Name, "", ErrPhysConnect)
-				continue
-			}
-			L.conns[sa.Cfg.Name] = 0 // no addr => no logical connection
-			log.Printf("connected to %s", sa.Cfg.Name)
-			SendActionStatusToApp(CONNECT, sa.Cfg.Name, "", "")
-		case OP_DISCONNECT:
-			// disconnect gracefully killing the ring
-			if L.myAddr != 0 {
-				killRing()
-			}
+	l.req = sa.Req
+	if a.AType == OP_SEND || a.AType == OP_GROUP_SEND {
+		l.out.push(sa)
+		return
+	}
+	// control operations go before data: nothing is sent while the ring is being changed
+	l.control(a.AType, sa)
+}
 
-			if err := com.ClosePort(sa.Addr); err != nil {
-				log.Printf("cannot disconnect from %s: %s", sa.Addr, err)
-				// SendActionStatusToApp(ERROR,"cannot disconnect from %s: %s", sa.Addr, err)
-				continue
-			}
-			log.Printf("successful disconnect from %s", sa.Addr)
-			SendActionStatusToApp(DISCONNECT, sa.Addr, "", "")
-			L.kickDeadConn(sa.Addr)
-		case OP_RING_CONNECT:
-			if L.myAddr == 0 {
-				port := L.getRandomPortName()
-				if port == "" {
-					SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
-					continue
-				}
-				firstAddr := minAddr // init our addr only after successful receiving this frame back
-				f, err := newFrame(broadcast, firstAddr, linkFrame, []byte{firstAddr})
-				if err != nil {
-					log.Printf("cannot ring connect: create link frame: %s", err)
-					SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
-					continue
-				}
-				L.tempAddr = firstAddr
-				sendToPort(port, f.Marshal())
-
-				// wait for our frame back
-				t := time.NewTimer(linkWait)
-				select {
-				case <-t.C:
-					log.Printf("initiator: cannot ring connect: timeout")
-					L.myAddr = 0
-					SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
-				case <-L.connected:
-					// inform other, that ring is closed
-					f, err := newFrame(broadcast, minAddr, linkOKFrame, nil)
-					if err != nil {
-						log.Printf("cannot ring connect: create link ok frame: %s", err)
-						SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
-						continue
-					}
-					sendToPort(port, f.Marshal())
-					L.myAddr = firstAddr
-					L.conns[port] = firstAddr + 1 // was for: next will have incremented addr
-					log.Printf("CONNECT_RING: 'OK', myAddr is %d, neighbors are %+v", L.myAddr, L.conns)
-					SendActionStatusToApp(CONNECT_RING, "OK", "", "")
-				}
-				t.Stop()
-			} else {
-				log.Printf("cannot ring connect: already connected")
-				SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
-			}
-		case OP_KILL_RING:
-			killRing()
-		case OP_SEND:
-			ma, ok := a.Data.(SystemAction)
-			if !ok {
-				log.Printf("cannot cast to SystemAction '%T'", ma)
-				continue
-			}
-			log.Printf("processing send of message: %+v", ma)
-			var addr byte
-			if ma.Addr != "" { // not broadcast
-				var ok bool
-				addr, ok = L.findAddrByPortName(ma.Addr)
-				if !ok {
-					log.Printf("cannot send message to %s", ma.Addr)
-					// sendSystemStatusToApp(NO_ACK, "disconnected")
-					// sendSystemStatusToApp(DISCONNECT, ma.Addr)
-					L.kickDeadConn(ma.Addr)
-					killRing()
-					continue
-				}
-			} else {
-				addr = broadcast
-			}
-			f, err := newFrame(addr, L.myAddr, iFrame, []byte(ma.Message))
-			if err != nil {
-				log.Printf("cannot put message to frame: %s", err)
-				// sendAnotherErrorToApp("cannot put message to frame: %s", err)
-				continue
-			}
-			if addr != broadcast {
-				sendToPort(ma.Addr, f.Marshal())
-				// let's wait for delivery
-				t := time.NewTimer(sendWait)
-				select {
-				case <-t.C:
-					log.Printf("fail to send")
-					SendActionStatusToApp(NO_ACK, "", "", "")
-				case <-l.delivered:
-				}
-				lastFrame = nil
-			} else {
-				port := L.getRandomPortName()
-				if port == "" {
-					// todo: disconnect
-					log.Printf("cannot ring disconnect: no port available")
-					// SendActionStatusToApp("cannot ring disconnect: no port available")
-					SendActionStatusToApp(NO_ACK, "", "", "")
-					continue
-				}
-				sendToPort(port, f.Marshal())
-				SendActionStatusToApp(ACK, "", "", "") // broadcast is ok
-			}
-		default:
-			log.Printf("unknown action type %d", a.AType)
-			SendActionStatusToApp(ERROR, "", "", ErrProtocolBug)
+// control performs operation on physical or logical connections.
+func (l *layer) control(op byte, sa SystemAction) {
+	switch op {
+	case OP_CONNECT:
+		if sa.Cfg == nil {
+			log.Printf("cannot connect: no cfg available")
+			l.SendActionStatusToApp(ERROR, "", "", ErrProtocolBug)
+			return
+		}
+		if _, ok := l.conns[sa.Cfg.Name]; ok {
+			log.Printf("cannot connect to %s: already connected", sa.Cfg.Name)
+			l.SendActionStatusToApp(ERROR, sa.Cfg.Name, "", ErrPhysConnect)
+			return
+		}
+		if err := l.tr.Connect(sa.Cfg); err != nil {
+			log.Printf("cannot connect to %s: %s", sa.Cfg.Name, err)
+			l.SendActionStatusToApp(ERROR, sa.Cfg.Name, "", ErrPhysConnect)
+			return
+		}
+		l.conns[sa.Cfg.Name] = 0 // no addr => no logical connection
+		l.ports[sa.Cfg.Name] = sa.Cfg
+		log.Printf("connected to %s", sa.Cfg.Name)
+		l.SendActionStatusToApp(CONNECT, sa.Cfg.Name, "", "")
+		if sa.Cfg.Name == l.lostPort && l.getState() == StateDegraded {
+			l.relink()
+		}
+	case OP_DISCONNECT:
+		if _, ok := l.conns[sa.Addr]; !ok {
+			log.Printf("cannot disconnect from %s: not open", sa.Addr)
+			l.SendActionStatusToApp(ERROR, sa.Addr, "", ErrNotOpen)
+			return
+		}
+		if err := l.tr.Close(sa.Addr); err != nil {
+			log.Printf("cannot disconnect from %s: %s", sa.Addr, err)
+			l.SendActionStatusToApp(ERROR, sa.Addr, "", ErrPhysConnect)
+			return
 		}
+		log.Printf("successful disconnect from %s", sa.Addr)
+		l.SendActionStatusToApp(DISCONNECT, sa.Addr, "", "")
+		neighbor := l.conns[sa.Addr]
+		l.kickDeadConn(sa.Addr)
+		if l.getState() == StateConnected {
+			l.degrade(sa.Addr, neighbor)
+		}
+	case OP_RING_CONNECT:
+		l.ringConnect()
+	case OP_KILL_RING:
+		if err := l.killRing(); err != nil {
+			l.SendActionStatusToApp(ERROR, "", "", ErrRingState)
+		}
+	case OP_GROUP_CREATE, OP_GROUP_JOIN, OP_GROUP_LEAVE:
+		l.groupControl(op, sa.Group)
+	case OP_SET_NICK:
+		l.setNick(sa.Message)
+	case OP_AUTO_RING:
+		l.auto = sa.Auto
+		log.Printf("auto ring: %t", l.auto)
+		l.SendActionStatusToApp(AUTO_RING, "", "", "%t", l.auto)
+	case OP_STATS:
+		stats := l.getStats()
+		l.toApp(STATS, ActionPayload{Stats: &stats})
+	case OP_SNAPSHOT:
+		l.toApp(SNAPSHOT, ActionPayload{Snapshot: l.snapshot()})
+	case OP_JOIN_RING:
+		l.joinRing()
+	case OP_LEAVE_RING:
+		l.leaveRing()
+	default:
+		log.Printf("unknown action type %d", op)
+		l.SendActionStatusToApp(ERROR, "", "", ErrProtocolBug)
 	}
 }
 
-func killRing() {
-	port := L.getRandomPortName()
+// ringConnect performs OP_RING_CONNECT: link frame passes the ring and
+// gives addrs to the nodes. Link frame carries stable id of the initiator,
+// so if two nodes start at the same time, the least id wins.
+func (l *layer) ringConnect() {
+	port := l.getRandomPortName()
 	if port == "" {
-		log.Printf("cannot ring disconnect: no port available")
-		// SendActionStatusToApp("cannot ring disconnect: no port available")
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
 		return
 	}
-	if L.myAddr != 0 {
-		f, err := newFrame(0, L.myAddr, uplinkFrame, nil)
-		if err != nil {
-			log.Printf("cannot ring disconnect: %s", err)
-			// sendAnotherErrorToApp("cannot ring disconnect: %s", err)
-			return
+	firstAddr := minAddr // init our addr only after successful receiving this frame back
+	f, err := l.newFrame(broadcast, firstAddr, linkFrame, append([]byte{firstAddr}, l.info.ID...))
+	if err != nil {
+		log.Printf("cannot ring connect: create link frame: %s", err)
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
+		return
+	}
+	if err := l.setState(StateLinking, "ring connect"); err != nil {
+		log.Printf("cannot ring connect: %s", err)
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingState)
+		return
+	}
+	l.linkSession = l.newSession()
+	l.linkPort = port
+	f.session = l.linkSession
+	l.sendToPort(port, f.Marshal())
+
+	// wait for our frame back
+	l.expect(func() {
+		log.Printf("initiator: cannot ring connect: timeout")
+		if err := l.setState(StateIdle, "link frame timeout"); err != nil {
+			log.Printf("initiator: %s", err)
 		}
-		L.myAddr = 0
-		sendToPort(port, f.Marshal())
-		for k := range L.conns {
-			L.conns[k] = 0
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
+	})
+}
+
+// linked finishes ring connect of the initiator, when link frame is back
+// with the ring size n.
+func (l *layer) linked(n byte, from string) {
+	l.fulfil()
+	// inform other, that ring is closed and how large it is
+	l.session = l.linkSession
+	f, err := l.newFrame(broadcast, minAddr, linkOKFrame, []byte{n})
+	if err != nil {
+		log.Printf("cannot ring connect: create link ok frame: %s", err)
+		if err := l.setState(StateIdle, "no link ok frame"); err != nil {
+			log.Printf("initiator: %s", err)
 		}
-	} else {
-		log.Printf("cannot ring disconnect: already disconnected")
-		// SendActionStatusToApp(ERROR, "")
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
+		return
 	}
-
-	SendActionStatusToApp(DISRUPTION, "", "", "")
+	l.sendToPort(l.linkPort, f.Marshal())
+	l.myAddr = minAddr
+	l.conns[from] = n
+	l.conns[l.linkPort] = minAddr + 1 // was for: next will have incremented addr
+	l.ring = ringOf(n)
+	if err := l.setState(StateConnected, "my link frame is back"); err != nil {
+		log.Printf("initiator: %s", err)
+	}
+	log.Printf("CONNECT_RING: 'OK', myAddr is %d, neighbors are %+v", l.myAddr, l.conns)
+	l.SendActionStatusToApp(CONNECT_RING, "OK", "", "")
+	l.announce()
+	l.announceGroups()
 }
 
-func (l *layer) kickDeadConn(name string) {
-	delete(l.conns, name)
-	l.lastDead = name
+// linkOK finishes ring connect of the node which has passed link frame of the session.
+func (l *layer) linkOK(n byte, session uint16) {
+	p := l.pending[session]
+	l.fulfil()
+	l.pending = make(map[uint16]*pendingLink)
+	if err := l.setState(StateConnected, "link ok"); err != nil {
+		log.Printf("not initiator: %s", err)
+		return
+	}
+	l.session = session
+	l.conns[p.from] = p.addr
+	l.myAddr = p.addr + 1
+	if l.myAddr != n {
+		l.conns[p.to] = p.addr + 2 // was for: next will have incremented addr
+	} else {
+		l.conns[p.to] = minAddr
+	}
+	l.ring = ringOf(n)
+	log.Printf("CONNECT_RING: 'OK', myAddr is %d, neighbors are %+v", l.myAddr, l.conns)
+	l.SendActionStatusToApp(CONNECT_RING, "OK", "", "")
+	l.announce()
+	l.announceGroups()
 }
 
-func (l *layer) listenToPhysLayer() {
-	var buf []byte
-	started := false
-	for got := range com.L.GotC {
-		log.Printf("got from phys layer: %+v", got)
-		if !started {
-			if hasStartByte(got.Data) {
-				started = true
-			} else {
-				// broken, skip
-				log.Printf("not valid frame: %+v", got)
-				continue
-			}
+// transmit sends queued message to the ring, ACK is waited by outbox.
+// Broadcast message carries receipts of ring members who've already got it.
+func (l *layer) transmit(ma SystemAction, got receipts) (byte, error) {
+	if l.myAddr == 0 {
+		// frame of session 0 is dropped as stale, don't wait for rebroadcasts
+		return 0, ErrNoRing
+	}
+	if ma.Group != "" {
+		return broadcast, l.transmitToGroup(ma)
+	}
+	addr, port := broadcast, ""
+	if ma.Addr != "" { // not broadcast
+		var err error
+		if addr, err = l.resolve(ma.Addr); err != nil {
+			return 0, err
 		}
-		buf = append(buf, got.Data...)
-		if len(buf) < minFrameLen {
-			// skip, we need more
-			log.Printf("not enough data (<%d), get next: %x", minFrameLen, got.Data)
-			continue
+		if port, err = l.route(addr); err != nil {
+			return 0, err
 		}
+	}
+	data := []byte(ma.Message)
+	if addr == broadcast {
+		data = append(got[:], data...)
+	}
+	f, err := l.newFrame(addr, l.myAddr, iFrame, data)
+	if err != nil {
+		return 0, fmt.Errorf("cannot put message to frame: %w", err)
+	}
+	f.id = ma.ID
+	if addr != broadcast {
+		l.lastFrame = f.Marshal()
+		l.sendToPort(port, l.lastFrame)
+		return addr, nil
+	}
 
-		// fType, err := getFrameType(buf)
-		// if err != nil {
-		// 	log.Printf("get frame type err: %s", err)
-		// 	// buf = nil?
-		// 	continue
-		// }
-		// frameSize := 0
-		// switch fType {
-		// case iFrame: // has len
-		// 	if len(buf) < minFrameLen+1 /* need len field */ || len(buf) < minFrameLen+1+int(buf[4]) /* len */ {
-		// 		// buf has not enough data, read more
-		// 		continue
-		// 	}
-		// 	frameSize = minFrameLen + 1 + int(buf[4])
-		// case linkFrame: // has len (=1)
-		// 	// if len(buf) < minFrameLen + 1 {
-		// 	//
-		// 	// }
-		// case linkOKFrame, uplinkFrame, ackFrame, retFrame:
-		// 	//
-		// default:
-		// 	// unknown
-		// 	// buf = nil?
-		// 	continue
-		// }
-
-		// if fType != iFrame {
-		if end := findEndOfFrame(buf[1:]); end != -1 { // finds first occurrence of stopByte
-			// we searched without first byte, real end:
-			end++
-			// gotcha frame
-			res := make([]byte, end+1) // fixme
-			copy(res, buf)
-
-			buf = []byte{}
-			if end+1 < len(buf) {
-				buf = append(buf, buf[end+1:]...)
-			}
-			if !isValidFrame(res) {
-				log.Printf("abnormal: frame should be valid: %v", res)
-			}
+	port = l.getRandomPortName()
+	if port == "" {
+		return 0, errors.New("no port available")
+	}
+	l.sendToPort(port, f.Marshal())
 
-			log.Printf("processing %x...", res)
-			// var ok bool
-			// res, ok = decode(res)
-			// if !ok {
-			// 	// broken, need to get this frame again
-			// 	addr, ok := L.findAddrByPortName(got.Name)
-			// 	if !ok {
-			// 		// connection is dead
-			// 		log.Printf("connection %s is dead", got.Name)
-			// 		SendActionStatusToApp(DISCONNECT, got.Name, "", "")
-			// 		L.kickDeadConn(got.Name)
-			// 		killRing()
-			// 		continue
-			// 	}
-			// 	f, err := newFrame(addr, L.myAddr, retFrame, nil)
-			// 	if err != nil {
-			// 		log.Printf("abnormal: cannot create retFrame: %s", err)
-			// 		continue
-			// 	}
-			// 	sendToPort(got.Name, f.Marshal())
-			// 	continue
-			// }
-
-			var f frame
-			if err := f.Unmarshal(res); err != nil {
-				log.Printf("cannot unmarshal %x", res)
-				continue
-			}
+	return addr, nil
+}
 
-			started = false
-			processFrame(&f, got.Name)
-		} else {
-			// broken?
-			log.Printf("not end, get next for: %x", got.Data)
-		}
-		// } else {
-		// 	if end := buf[frameSize]; end != stopByte {
-		// 		log.Printf("wrong frame, end byte is not stop")
-		// 		buf = buf[frameSize:]
-		// 		continue
-		// 	}
-		// 	var f frame
-		// 	if err := f.Unmarshal(buf[:frameSize]); err != nil {
-		// 		log.Printf("cannot unmarshal %x", buf[:frameSize])
-		// 		continue
-		// 	}
-		//
-		// 	started = false
-		// 	processFrame(&f, got.Name)
-		// }
+// killRing sends uplink frame and forgets the ring. Others forget it too
+// when the frame passes them.
+func (l *layer) killRing() error {
+	if err := l.setState(StateTearingDown, "ring kill"); err != nil {
+		log.Printf("cannot ring disconnect: %s", err)
+		return err
+	}
+	f, err := l.newFrame(0, l.myAddr, uplinkFrame, nil)
+	if err != nil {
+		log.Printf("cannot ring disconnect: %s", err)
+	} else if port := l.getRandomPortName(); port == "" {
+		log.Printf("cannot ring disconnect: no port available")
+	} else {
+		l.sendToPort(port, f.Marshal())
+	}
+	l.resetRing()
+	l.session = 0
+	if err := l.setState(StateIdle, "ring is killed"); err != nil {
+		log.Printf("abnormal: %s", err)
+	}
+	l.SendActionStatusToApp(DISRUPTION, "", "", "")
+
+	return nil
+}
+
+// resetRing forgets everything about the ring, ports stay connected.
+func (l *layer) resetRing() {
+	l.myAddr = 0
+	l.ring = nil
+	l.lostPort = ""
+	l.groups.reset()
+	l.roster.reset()
+	l.SendRosterToApp(nil)
+	for k := range l.conns {
+		l.conns[k] = 0
 	}
 }
 
-func getFrameType(b []byte) (byte, error) {
-	if len(b) < minFrameLen {
-		return 0, errors.New("frame has not enough len")
+func (l *layer) kickDeadConn(name string) {
+	delete(l.conns, name)
+	delete(l.ports, name)
+	delete(l.decoders, name)
+	l.lastDead = name
+}
+
+// gotChunk processes frames of the chunk read from the port.
+func (l *layer) gotChunk(got *com.SendInfo) {
+	logs.Debugf("got from phys layer: %+v", got)
+	d, ok := l.decoders[got.Name]
+	if !ok {
+		d = &decoder{}
+		l.decoders[got.Name] = d
 	}
+	d.write(got.Data)
+
+	for {
+		f, err := d.next()
+		if err != nil {
+			log.Printf("drop broken frame from %s: %s", got.Name, err)
+			continue
+		}
+		if f == nil {
+			// need more data
+			break
+		}
 
-	return b[3], nil
+		l.processFrame(f, got.Name)
+	}
 }
 
 func (l *layer) findAddrByPortName(name string) (byte, bool) {
@@ -364,6 +410,34 @@ func (l *layer) findPortNameByAddr(addr byte) string {
 	return ""
 }
 
+func (l *layer) transmitToGroup(ma SystemAction) error {
+	if !validGroupName(ma.Group) {
+		return ErrGroupName
+	}
+	f, err := l.newFrame(broadcast, l.myAddr, groupMsgFrame, groupMessageData(ma.Group, ma.Message))
+	if err != nil {
+		return fmt.Errorf("cannot put message to frame: %w", err)
+	}
+	f.id = ma.ID
+	port := l.getRandomPortName()
+	if port == "" {
+		return errors.New("no port available")
+	}
+	l.sendToPort(port, f.Marshal())
+
+	return nil
+}
+
+// ringOf returns members of the ring which was linked with n computers.
+func ringOf(n byte) []byte {
+	ring := make([]byte, 0, n)
+	for a := minAddr; a <= n && a <= maxAddr; a++ {
+		ring = append(ring, a)
+	}
+
+	return ring
+}
+
 func (l *layer) getRandomPortName() string {
 	for pn := range l.conns {
 		return pn
@@ -372,6 +446,20 @@ func (l *layer) getRandomPortName() string {
 	return ""
 }
 
+// nextPort returns the port to the next member in ring order. Frames sent to it
+// follow link ok and member frames, so they come to the nodes which already know the ring.
+func (l *layer) nextPort() string {
+	for i, a := range l.ring {
+		if a == l.myAddr {
+			if port := l.findPortNameByAddr(l.ring[(i+1)%len(l.ring)]); port != "" {
+				return port
+			}
+		}
+	}
+
+	return l.getRandomPortName()
+}
+
 func (l *layer) getAnotherPort(addr string) string {
 	for pn := range l.conns {
 		if pn != addr {
@@ -382,208 +470,507 @@ func (l *layer) getAnotherPort(addr string) string {
 	return ""
 }
 
-func processFrame(f *frame, from string) {
-	log.Printf("processing frame %+v from %s...", f, from)
+func (l *layer) processFrame(f *frame, from string) {
+	logs.Debugf("processing frame %+v from %s...", f, from)
+	if !l.inSession(f) {
+		l.dropStale(f)
+		return
+	}
+	if l.passThrough(f) {
+		port := l.getAnotherPort(from)
+		if port == "" {
+			log.Printf("pass through: cannot find another port")
+			return
+		}
+		l.forward(port, f)
+		return
+	}
+
 	switch f.fType {
 	case iFrame:
 		// get message!
-		log.Printf("message frame: %+v, active ports: %+v", f, L.conns)
+		logs.Debugf("message frame: %+v, active ports: %+v", f, l.conns)
+		msg := f.data
 		if f.dest == broadcast {
-			if f.src == L.myAddr {
+			if len(f.data) < receiptsLen {
+				log.Printf("got strange broadcast frame (no receipts): %+v", f)
+				return
+			}
+			var got receipts
+			copy(got[:], f.data)
+			if f.src == l.myAddr {
+				// went round the ring
+				if !l.out.broadcastBack(f.id, got) {
+					log.Printf("broadcast %d is back, but nobody waits for it", f.id)
+				}
 				return
 			}
-			port := L.getAnotherPort(from)
+			fresh := !got.has(l.myAddr)
+			got.set(l.myAddr)
+			copy(f.data, got[:])
+			port := l.getAnotherPort(from)
 			if port == "" {
 				log.Printf("broadcast message: disconnect, cannot find another port")
-				SendActionStatusToApp(DISCONNECT, L.lastDead, "", "")
-				killRing()
+				l.cannotPass()
 				return
 			}
-			sendToPort(port, f.Marshal())
-			if f.src == L.myAddr {
+			l.forward(port, f)
+			if !fresh {
+				// rebroadcast for those who missed it, we've already got it
 				return
 			}
-		} else if f.dest != L.myAddr { // this should not happen, because we have 3 computers
-			// not my message, pass to the next
-			port := L.getAnotherPort(from)
+			msg = f.data[receiptsLen:]
+		} else if f.dest != l.myAddr {
+			// not my message, pass to the next in the same direction
+			port := l.getAnotherPort(from)
 			if port == "" {
 				log.Printf("not my message: disconnect, cannot find another port")
-				SendActionStatusToApp(DISCONNECT, L.lastDead, "", "")
-				killRing()
+				l.cannotPass()
 				return
 			}
-			sendToPort(port, f.Marshal())
+			l.forward(port, f)
 			return
 		}
 
-		// 3 computers, so we know all port names
-		port := L.findPortNameByAddr(f.src)
-		if port == "" {
-			log.Printf("my message: disconnect, cannot find another port")
-			SendActionStatusToApp(DISCONNECT, L.lastDead, "", "")
-			killRing()
-			return
-		}
-		// L.SendAppC <- &Action{
-		// 	AType: OP_SEND,
-		// 	Data: SystemAction{
-		// 		Addr:    port,
-		// 		Message: string(f.data),
-		// 	},
-		// }
 		if f.dest != broadcast {
-			SendActionStatusToApp(MESSAGE, port, "not_broadcast", string(f.data))
+			ack, err := l.newFrame(f.src, l.myAddr, ackFrame, nil)
+			if err != nil {
+				log.Printf("abnormal: cannot create ackFrame: %s", err)
+				return
+			}
+			ack.id = f.id
+			l.sendToPort(from, ack.Marshal()) // ack goes back the same way
+			l.sendMessageToApp(f.id, f.src, "not_broadcast", string(msg))
 		} else {
-			SendActionStatusToApp(MESSAGE, port, "", string(f.data))
+			l.sendMessageToApp(f.id, f.src, "", string(msg))
 		}
 	case linkFrame:
 		// set ring conns
-		if f.len != 1 {
-			log.Printf("got strange link frame (len != 1): %+v", f)
+		if f.len < 1 {
+			log.Printf("got strange link frame (no addr): %+v", f)
 			return
 		}
-		if L.myAddr == 0 {
-			if f.src != L.tempAddr {
-				port := L.getAnotherPort(from)
+		initiator := string(f.data[1:]) // stable id
+		if l.myAddr == 0 {
+			linking := l.getState() == StateLinking
+			if linking && initiator != l.info.ID {
+				// somebody else has started ring connect at the same time
+				if initiator > l.info.ID {
+					log.Printf("drop link frame of %s, my ring connect goes on", initiator)
+					return
+				}
+				log.Printf("link frame of %s wins, stop my ring connect", initiator)
+				l.fulfil()
+				if err := l.setState(StateAwaitingLinkOK, "link frame of "+initiator+" wins"); err != nil {
+					log.Printf("abnormal: %s", err)
+				}
+				l.SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
+			}
+			if initiator == l.info.ID && !linking {
+				log.Printf("got my link frame back, but ring connect is stopped")
+				return
+			}
+			if initiator != l.info.ID {
+				port := l.getAnotherPort(from)
 				if port == "" {
 					log.Printf("disconnect, cannot find another port")
-					SendActionStatusToApp(DISCONNECT, L.lastDead, "", "")
+					l.SendActionStatusToApp(DISCONNECT, l.lastDead, "", "")
 					return
 				}
 
-				newF, err := newFrame(broadcast, f.src, linkFrame, []byte{f.data[0] + 1})
+				newF, err := l.newFrame(broadcast, f.src, linkFrame, append([]byte{f.data[0] + 1}, f.data[1:]...))
 				if err != nil {
 					log.Printf("abnormal: new link frame err: %s", err)
 					return
 				}
-				sendToPort(port, newF.Marshal())
-
-				// wait in another goroutine, because link ok frame comes to the same channel as this link frame
-				go func() {
-					t := time.NewTimer(linkWait)
-					select {
-					case <-t.C:
-						log.Printf("not initiator: cannot ring connect: timeout")
-						SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
-					case <-L.connected:
-						L.conns[from] = f.data[0]
-						L.myAddr = f.data[0] + 1
-						if f.data[0]+2 != 4 { // let's think there are 3 computers, need to fix
-							L.conns[port] = f.data[0] + 2 // was for: next will have incremented addr
-						} else {
-							L.conns[port] = minAddr
-						}
-						log.Printf("CONNECT_RING: 'OK', myAddr is %d, neighbors are %+v", L.myAddr, L.conns)
-						SendActionStatusToApp(CONNECT_RING, "OK", "", "")
+				newF.session = f.session
+				newF.hops = f.hops
+				if l.getState() == StateIdle {
+					if err := l.setState(StateAwaitingLinkOK, "link frame is passed"); err != nil {
+						log.Printf("abnormal: %s", err)
 					}
-					t.Stop()
-				}()
+				}
+				l.pending[f.session] = &pendingLink{from: from, to: port, addr: f.data[0]}
+				l.forward(port, newF)
+
+				// link ok frame comes round the ring
+				l.expect(func() {
+					log.Printf("not initiator: cannot ring connect: timeout")
+					l.pending = make(map[uint16]*pendingLink)
+					if err := l.setState(StateIdle, "link ok timeout"); err != nil {
+						log.Printf("not initiator: %s", err)
+						return
+					}
+					l.SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
+				})
+			} else if f.session != l.linkSession {
+				log.Printf("got my link frame of the previous ring connect")
 			} else {
 				// we got frame back, logical conn is ok
-				select {
-				case L.connected <- struct{}{}:
-					log.Println("link frame: got back")
-					L.conns[from] = f.data[0] // we've already checked data len
-				default:
-					log.Println("abnormal: we got link frame with our src, but we don't listen for it...")
-				}
+				log.Println("link frame: got back")
+				l.linked(f.data[0], from) // the last addr is the ring size
 			}
 		} else {
 			log.Println("got link frame, but already connected")
 		}
 	case linkOKFrame:
-		if L.myAddr == 0 {
-			select {
-			case L.connected <- struct{}{}:
-				log.Println("link ok frame: success")
-			default:
-				log.Println("abnormal: we got link ok frame, but we don't listen for it...")
-			}
+		if f.len != 1 {
+			log.Printf("got strange link ok frame (len != 1): %+v", f)
+			return
+		}
+		if l.myAddr == 0 {
 			// broadcast: pass the frame anyway
-			port := L.getAnotherPort(from)
+			port := l.getAnotherPort(from)
 			if port == "" {
 				log.Printf("disconnect, cannot find another port")
-				SendActionStatusToApp(DISCONNECT, L.lastDead, "", "")
+				l.SendActionStatusToApp(DISCONNECT, l.lastDead, "", "")
 				return
 			}
-			sendToPort(port, f.Marshal())
+			l.forward(port, f)
+			if l.pending[f.session] == nil || l.getState() != StateAwaitingLinkOK {
+				log.Println("abnormal: we got link ok frame, but we don't listen for it...")
+				return
+			}
+			log.Println("link ok frame: success")
+			l.linkOK(f.data[0], f.session)
 		} else {
 			log.Println("got link ok frame, but already connected")
 		}
 	case uplinkFrame:
-		if L.myAddr != 0 {
-			if f.src != L.myAddr {
-				port := L.getAnotherPort(from)
+		if l.myAddr != 0 {
+			if f.src != l.myAddr {
+				port := l.getAnotherPort(from)
 				if port == "" {
 					log.Printf("disconnect, cannot find another port")
-					SendActionStatusToApp(DISCONNECT, L.lastDead, "", "")
+					l.SendActionStatusToApp(DISCONNECT, l.lastDead, "", "")
 				} else {
-					sendToPort(port, f.Marshal())
+					l.forward(port, f)
 				}
 			}
-			log.Println("")
-			L.myAddr = 0
-			SendActionStatusToApp(DISRUPTION, "", "", "")
+			if err := l.setState(StateTearingDown, "got uplink"); err != nil {
+				log.Printf("uplink: %s", err)
+				return
+			}
+			l.resetRing()
+			l.session = 0
+			if err := l.setState(StateIdle, "ring is killed"); err != nil {
+				log.Printf("abnormal: %s", err)
+			}
+			l.SendActionStatusToApp(DISRUPTION, "", "", "")
 		} else {
 			log.Printf("got uplink back")
 		}
 	case ackFrame:
+		if f.dest != l.myAddr {
+			// not my ack, pass to the next
+			port := l.getAnotherPort(from)
+			if port == "" {
+				log.Printf("not my ack: disconnect, cannot find another port")
+				l.cannotPass()
+				return
+			}
+			l.forward(port, f)
+			return
+		}
 		// successful delivery
-		L.delivered <- struct{}{}
-		log.Printf("ACK, last frame %+x", lastFrame)
-		// from: f.src, to: f.dest
-		var to string
-		if f.dest != 0 {
-			to = L.findPortNameByAddr(f.dest)
-		}
-		SendActionStatusToApp(ACK, "", to, "")
-		lastFrame = nil
+		logs.Debugf("ACK of message %d, last frame %+x", f.id, l.lastFrame)
+		if !l.out.acked(f.src, f.id) {
+			log.Printf("ACK of message %d from %d, but nobody waits for it", f.id, f.src)
+		}
+	case groupFrame:
+		if f.src == l.myAddr {
+			// went round the ring
+			return
+		}
+		name, err := l.groups.apply(f.src, f.data)
+		if err != nil {
+			log.Printf("got strange group frame %+v: %s", f, err)
+		} else {
+			l.SendGroupStatusToApp(name, l.groups.list(name))
+		}
+		port := l.getAnotherPort(from)
+		if port == "" {
+			log.Printf("group frame: disconnect, cannot find another port")
+			l.cannotPass()
+			return
+		}
+		l.forward(port, f)
+	case groupMsgFrame:
+		if f.src == l.myAddr {
+			// went round the ring, every member has got it
+			if !l.out.groupBack(f.id) {
+				log.Printf("group message %d is back, but nobody waits for it", f.id)
+			}
+			return
+		}
+		// pass the frame anyway, other members are further
+		port := l.getAnotherPort(from)
+		if port == "" {
+			log.Printf("group message: disconnect, cannot find another port")
+			l.cannotPass()
+			return
+		}
+		l.forward(port, f)
+
+		name, msg, ok := parseGroupMessage(f.data)
+		if !ok {
+			log.Printf("got strange group message frame: %+v", f)
+			return
+		}
+		if l.groups.isMember(name, l.myAddr) {
+			l.sendGroupMessageToApp(f.id, f.src, name, msg)
+		}
+	case announceFrame:
+		if f.src == l.myAddr {
+			// went round the ring
+			return
+		}
+		l.gotAnnounce(f)
+		port := l.getAnotherPort(from)
+		if port == "" {
+			log.Printf("announce frame: disconnect, cannot find another port")
+			l.cannotPass()
+			return
+		}
+		l.forward(port, f)
+	case electFrame:
+		l.gotElect(f, from)
+	case joinFrame:
+		l.gotJoin(f, from)
+	case memberFrame:
+		l.gotMemberChange(f, from)
 	case retFrame:
 		// resend last frame
-		log.Printf("RET, last frame %+x", lastFrame)
-		sendToPort(from, lastFrame)
+		logs.Debugf("RET, last frame %+x", l.lastFrame)
+		l.sendToPort(from, l.lastFrame)
 	default:
 		// unknown frame
 	}
 }
 
-func SendActionStatusToApp(op byte, addr, messageTo, messageFormat string, a ...interface{}) {
-	L.GetAppC <- &Action{
+// toApp sends status to app layer, it carries the request which caused it.
+func (l *layer) toApp(op byte, p ActionPayload) {
+	p.Req = l.req
+	if op == MESSAGE {
+		l.remember(p)
+	}
+	l.GetAppC <- &Action{
 		AType: op,
-		Data: ActionPayload{
-			Addr:    addr,
-			Message: fmt.Sprintf(messageFormat, a...),
-			To:      messageTo,
-		},
+		Data:  p,
 	}
 }
 
-func GetActionStatusFromApp(op byte, addr string, cfg *com.Config, message string) {
-	L.SendAppC <- &Action{
+func (l *layer) SendActionStatusToApp(op byte, addr, messageTo, messageFormat string, a ...interface{}) {
+	l.toApp(op, ActionPayload{
+		Addr:    addr,
+		Message: fmt.Sprintf(messageFormat, a...),
+		To:      messageTo,
+	})
+}
+
+// SendMessageStatusToApp informs app layer about delivery of the message with id.
+func (l *layer) SendMessageStatusToApp(op byte, id uint16, messageTo, messageFormat string, a ...interface{}) {
+	l.toApp(op, ActionPayload{
+		ID:      id,
+		Message: fmt.Sprintf(messageFormat, a...),
+		To:      messageTo,
+	})
+}
+
+// sendMessageToApp passes got message as is, it is not a format string.
+func (l *layer) sendMessageToApp(id uint16, src byte, messageTo, message string) {
+	l.toApp(MESSAGE, ActionPayload{
+		ID:      id,
+		Addr:    strconv.Itoa(int(src)),
+		Nick:    l.roster.nick(src),
+		Peer:    l.roster.id(src),
+		Message: message,
+		To:      messageTo,
+	})
+}
+
+// GetActionStatusFromApp performs control op, req is echoed in its statuses.
+func GetActionStatusFromApp(req string, op byte, addr string, cfg *com.Config, message string) {
+	L.act(&Action{
 		AType: op,
 		Data: SystemAction{
+			Req:     req,
 			Addr:    addr,
 			Cfg:     cfg,
 			Message: message,
 		},
+	})
+}
+
+// GetAutoRingFromApp switches auto ring mode.
+func GetAutoRingFromApp(req string, auto bool) {
+	L.act(&Action{
+		AType: OP_AUTO_RING,
+		Data: SystemAction{
+			Req:  req,
+			Auto: auto,
+		},
+	})
+}
+
+// GetMessageFromApp queues message to send and returns at once,
+// id may be 0, then it is assigned by data layer.
+func GetMessageFromApp(req string, id uint16, addr, message string) {
+	L.act(&Action{
+		AType: OP_SEND,
+		Data: SystemAction{
+			Req:     req,
+			ID:      id,
+			Addr:    addr,
+			Message: message,
+		},
+	})
+}
+
+// SendBroadcastStatusToApp informs app layer which ring members got broadcast message with id.
+func (l *layer) SendBroadcastStatusToApp(op byte, id uint16, delivered, missed []int) {
+	l.toApp(op, ActionPayload{
+		ID:        id,
+		Delivered: delivered,
+		Missed:    missed,
+	})
+}
+
+// SendGroupStatusToApp informs app layer about members of the group.
+func (l *layer) SendGroupStatusToApp(name string, members []int) {
+	l.toApp(GROUP, ActionPayload{
+		Group:   name,
+		Members: members,
+	})
+}
+
+func (l *layer) sendGroupMessageToApp(id uint16, src byte, group, message string) {
+	l.toApp(MESSAGE, ActionPayload{
+		ID:      id,
+		Addr:    strconv.Itoa(int(src)),
+		Nick:    l.roster.nick(src),
+		Peer:    l.roster.id(src),
+		Message: message,
+		Group:   group,
+	})
+}
+
+// GetGroupMessageFromApp queues message to the group, like GetMessageFromApp.
+func GetGroupMessageFromApp(req string, id uint16, group, message string) {
+	L.act(&Action{
+		AType: OP_GROUP_SEND,
+		Data: SystemAction{
+			Req:     req,
+			ID:      id,
+			Group:   group,
+			Message: message,
+		},
+	})
+}
+
+// GetGroupActionFromApp creates, joins or leaves the group.
+func GetGroupActionFromApp(req string, op byte, group string) {
+	L.act(&Action{
+		AType: op,
+		Data: SystemAction{
+			Req:   req,
+			Group: group,
+		},
+	})
+}
+
+// SendNodeStatusToApp informs app layer that ring member has joined or changed its info.
+func (l *layer) SendNodeStatusToApp(event string, n NodeInfo) {
+	l.toApp(NODE, ActionPayload{
+		Addr:    strconv.Itoa(int(n.Addr)),
+		Message: event,
+		Node:    &n,
+	})
+}
+
+// SendRosterToApp sends all known ring members.
+func (l *layer) SendRosterToApp(nodes []NodeInfo) {
+	l.toApp(ROSTER, ActionPayload{
+		Roster: nodes,
+	})
+}
+
+// SendStateToApp publishes transition of the ring state machine.
+func (l *layer) SendStateToApp(from, to RingState, reason string) {
+	l.toApp(RING_STATE, ActionPayload{
+		Message: to.String(),
+		State:   &StateChange{From: from.String(), To: to.String(), Reason: reason},
+	})
+}
+
+// SendMemberStatusToApp informs app layer that node with stable id has joined or left the ring.
+func (l *layer) SendMemberStatusToApp(op byte, id string, ring []byte) {
+	event := nodeJoin
+	if op == memberLeave {
+		event = nodeLeave
 	}
+	members := make([]int, 0, len(ring))
+	for _, a := range ring {
+		members = append(members, int(a))
+	}
+	l.toApp(RING_CHANGE, ActionPayload{
+		Message: event,
+		Peer:    id,
+		Members: members,
+	})
+}
+
+// SendQueueStatusToApp informs app layer about outgoing messages backlog.
+func (l *layer) SendQueueStatusToApp(qs *QueueStatus) {
+	l.toApp(QUEUE, ActionPayload{
+		Queue: qs,
+	})
+}
+
+func (l *layer) sendToPort(addr string, data []byte) {
+	l.tr.Send(addr, data)
 }
 
-func sendToPort(addr string, data []byte) {
-	com.L.SendC <- &com.SendInfo{
-		Name: addr,
-		Data: data,
+// Options tune data layer, zero fields are defaults.
+type Options struct {
+	QueueLen int
+	LinkWait time.Duration
+	SendWait time.Duration
+}
+
+// ResultWait is the longest wait for the final status of request with the given
+// timeouts, zero ones are defaults: the ring is linked, then broadcast is sent
+// with all rebroadcasts.
+func ResultWait(link, send time.Duration) time.Duration {
+	if link <= 0 {
+		link = linkWait
 	}
+	if send <= 0 {
+		send = sendWait
+	}
+
+	return link + send*(maxRebroadcasts+1)
 }
 
-func Init() {
-	L = newLayer(queueLen)
-	go L.listenToAppLayer()
-	go L.listenToPhysLayer()
+// Init starts data layer of the node with stable id over com ports,
+// it works until Shutdown or ctx is done.
+func Init(ctx context.Context, id string, o Options) {
+	if o.QueueLen <= 0 {
+		o.QueueLen = queueLen
+	}
+	L = newLayer(o.QueueLen, id, comTransport{})
+	if o.LinkWait > 0 {
+		L.linkWait = o.LinkWait
+	}
+	if o.SendWait > 0 {
+		L.sendWait = o.SendWait
+	}
+	L.start(ctx)
+	go L.autoRing(ctx)
 }
 
-func Close() {
-	close(L.SendAppC)
-	close(L.GetAppC)
-	close(L.delivered)
+// Shutdown tells the ring that the node is leaving and stops data layer,
+// GetAppC is closed when it's done.
+func Shutdown(ctx context.Context) error {
+	return L.shutdown(ctx)
 }
@@ -0,0 +1,130 @@
+package datalayer
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrShutdown is the cause of messages failed by shutdown of the node.
+var ErrShutdown = errors.New("node is shutting down")
+
+// start runs the loop until stop or ctx is done.
+func (l *layer) start(ctx context.Context) {
+	ctx, l.stop = context.WithCancel(ctx)
+	go l.run(ctx)
+}
+
+// run is the event loop of the layer, the only goroutine which touches its state.
+// It handles actions of app layer, chunks of physical layer and funcs posted
+// by timers. Outbox dispatches messages after every event.
+// GetAppC is closed when the loop is done.
+func (l *layer) run(ctx context.Context) {
+	defer close(l.GetAppC)
+	defer close(l.done)
+	chunks := l.tr.Chunks()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case a := <-l.SendAppC:
+			l.handleAction(a)
+		case got, ok := <-chunks:
+			if !ok {
+				return
+			}
+			l.gotChunk(got)
+		case fn := <-l.events:
+			fn()
+		}
+		if l.out.kicked {
+			l.out.dispatch()
+		}
+		l.req = ""
+	}
+}
+
+// post runs fn in the loop, it's for timers and other goroutines.
+func (l *layer) post(fn func()) {
+	select {
+	case l.events <- fn:
+	case <-l.done:
+	}
+}
+
+// act passes action of app layer to the loop, it's dropped if the loop is done.
+func (l *layer) act(a *Action) {
+	select {
+	case l.SendAppC <- a:
+	case <-l.done:
+	}
+}
+
+// shutdown kills the ring, so members know that I'm leaving, fails messages
+// of the outbox and stops the loop. It gives up when ctx is done, the loop may be
+// stuck sending to app or physical layer.
+func (l *layer) shutdown(ctx context.Context) error {
+	killed := make(chan struct{})
+	l.post(func() {
+		switch l.getState() {
+		case StateConnected, StateDegraded:
+			l.killRing()
+		}
+		l.out.abort(ErrShutdown)
+		close(killed)
+	})
+	var err error
+	select {
+	case <-killed:
+	case <-l.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	l.stop()
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return err
+}
+
+// after runs fn in the loop after d.
+func (l *layer) after(d time.Duration, fn func()) *time.Timer {
+	return time.AfterFunc(d, func() { l.post(fn) })
+}
+
+// expect waits for the end of ring op in progress (ring connect, join or leave),
+// timeout is called if it doesn't come in time.
+// Statuses of the end carry the request which has started the op.
+func (l *layer) expect(timeout func()) {
+	l.cancelWait()
+	id, req := l.waitID, l.req
+	l.waitReq = req
+	l.waitTimer = l.after(l.linkWait, func() {
+		if l.waitID == id {
+			l.waitTimer = nil
+			l.req = req
+			timeout()
+		}
+	})
+}
+
+// fulfil ends ring op in progress: cancels its timeout and restores its request
+// for the statuses of the current event.
+func (l *layer) fulfil() {
+	if l.waitTimer != nil {
+		l.req = l.waitReq
+	}
+	l.cancelWait()
+}
+
+func (l *layer) cancelWait() {
+	l.waitReq = ""
+	l.waitID++
+	if l.waitTimer != nil {
+		l.waitTimer.Stop()
+		l.waitTimer = nil
+	}
+}
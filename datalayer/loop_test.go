@@ -0,0 +1,48 @@
+package datalayer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	// message waits while the ring is being linked, shutdown fails it
+	l := newLayer(64, "me", &sentPorts{})
+	if err := l.setState(StateLinking, "test"); err != nil {
+		t.Fatal(err)
+	}
+	l.start(context.Background())
+	l.act(&Action{AType: OP_SEND, Data: SystemAction{ID: 7, Addr: "2", Message: "hi", Req: "a/1"}})
+	for a := range l.GetAppC {
+		if a.AType == PENDING {
+			break
+		}
+	}
+	if err := l.shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var failed bool
+	for a := range l.GetAppC {
+		if p, ok := a.Data.(ActionPayload); ok && a.AType == TIMEOUT {
+			failed = p.ID == 7 && p.Req == "a/1" && errors.Is(p.Err, ErrShutdown)
+		}
+	}
+	if !failed {
+		t.Error("queued message is not failed")
+	}
+
+	// nobody reads statuses, the loop is stuck
+	l = newLayer(1, "me", &sentPorts{})
+	l.start(context.Background())
+	l.GetAppC <- &Action{}
+	l.act(&Action{AType: OP_SNAPSHOT, Data: SystemAction{}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, expected deadline", err)
+	}
+	for range l.GetAppC {
+	}
+}
@@ -0,0 +1,304 @@
+package datalayer
+
+import (
+	"errors"
+	"log"
+	"strconv"
+)
+
+// membership change ops in memberFrame
+const (
+	memberJoin  byte = 1
+	memberLeave byte = 2
+)
+
+var (
+	ErrWrongMemberChange = errors.New("wrong member change")
+)
+
+// memberChange is data of memberFrame. It is sent round the ring by the member
+// next to the joined node or by the leaving node. The new ring goes in the order
+// of the frame pass, so every node knows its neighbors: the previous one is
+// behind the port frame came from, the next one is behind another port.
+type memberChange struct {
+	op   byte
+	addr byte   // joined or leaving node
+	ring []byte // new ring
+	id   string // stable id of joined or leaving node
+}
+
+func (m *memberChange) marshal() []byte {
+	data := make([]byte, 0, 3+len(m.ring)+len(m.id))
+	data = append(data, m.op, m.addr, byte(len(m.ring)))
+	data = append(data, m.ring...)
+	data = append(data, m.id...)
+
+	return data
+}
+
+func parseMemberChange(data []byte) (*memberChange, error) {
+	if len(data) < 3 || len(data) < 3+int(data[2]) {
+		return nil, ErrWrongMemberChange
+	}
+	n := 3 + int(data[2])
+	m := &memberChange{
+		op:   data[0],
+		addr: data[1],
+		ring: append([]byte{}, data[3:n]...),
+		id:   string(data[n:]),
+	}
+	if m.op != memberJoin && m.op != memberLeave {
+		return nil, ErrWrongMemberChange
+	}
+
+	return m, nil
+}
+
+// orient returns the ring members starting from addr in the direction of next.
+func orient(ring []byte, addr, next byte) []byte {
+	n := len(ring)
+	i := 0
+	for i < n && ring[i] != addr {
+		i++
+	}
+	if i == n {
+		return nil
+	}
+	step := 1
+	if ring[(i+1)%n] != next {
+		step = n - 1 // backward
+	}
+	res := make([]byte, 0, n)
+	for k := 0; k < n; k++ {
+		res = append(res, ring[(i+k*step)%n])
+	}
+
+	return res
+}
+
+// freeAddr returns the least addr which is not used in the ring.
+func (l *layer) freeAddr() (byte, bool) {
+	for a := minAddr; a <= maxAddr; a++ {
+		if !l.isRingMember(a) {
+			return a, true
+		}
+	}
+
+	return 0, false
+}
+
+// joinRing performs OP_JOIN_RING: the node is inserted between two ring members
+// and asks the one behind any port for an addr.
+func (l *layer) joinRing() {
+	if len(l.conns) != 2 {
+		log.Printf("cannot join ring: need 2 connected ports, have %d", len(l.conns))
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
+		return
+	}
+	port := l.getRandomPortName()
+	f, err := l.newFrame(broadcast, 0, joinFrame, []byte(l.info.ID))
+	if err != nil {
+		log.Printf("cannot join ring: create join frame: %s", err)
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
+		return
+	}
+	if err := l.setState(StateAwaitingLinkOK, "join"); err != nil {
+		log.Printf("cannot join ring: %s", err)
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingState)
+		return
+	}
+	l.joining = true
+	l.sendToPort(port, f.Marshal())
+
+	// member change frame comes round the ring
+	l.expect(func() {
+		log.Printf("cannot join ring: timeout")
+		l.joining = false
+		if err := l.setState(StateIdle, "join timeout"); err != nil {
+			log.Printf("join ring: %s", err)
+		}
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingConnect)
+	})
+}
+
+// joined finishes OP_JOIN_RING, when member frame of my join has come.
+func (l *layer) joined() {
+	l.fulfil()
+	l.joining = false
+	if err := l.setState(StateConnected, "joined"); err != nil {
+		log.Printf("join ring: %s", err)
+	}
+	log.Printf("CONNECT_RING: 'OK', joined, myAddr is %d, neighbors are %+v", l.myAddr, l.conns)
+	l.SendActionStatusToApp(CONNECT_RING, "OK", "", "")
+}
+
+// gotJoin gives addr to the node inserted behind the port and informs the ring.
+func (l *layer) gotJoin(f *frame, from string) {
+	if l.myAddr == 0 {
+		log.Printf("got join frame, but not connected")
+		return
+	}
+	addr, ok := l.freeAddr()
+	if !ok {
+		log.Printf("cannot accept joining node: no free addr")
+		return
+	}
+	other := l.getAnotherPort(from)
+	if other == "" {
+		log.Printf("cannot accept joining node: no another port")
+		return
+	}
+	ring := orient(l.ring, l.myAddr, l.conns[other])
+	if ring == nil {
+		log.Printf("abnormal: I'm not in the ring %v", l.ring)
+		return
+	}
+	m := &memberChange{
+		op:   memberJoin,
+		addr: addr,
+		ring: append(ring, addr),
+		id:   string(f.data),
+	}
+	mf, err := l.newFrame(broadcast, l.myAddr, memberFrame, m.marshal())
+	if err != nil {
+		log.Printf("cannot accept joining node: %s", err)
+		return
+	}
+	log.Printf("node %s joins behind %s with addr %d", m.id, from, addr)
+	l.sendToPort(other, mf.Marshal())
+	l.applyMemberChange(m, 0, from, other)
+}
+
+// leaveRing performs OP_LEAVE_RING: neighbors are told to expect each other
+// on the ports which were connected to me.
+func (l *layer) leaveRing() {
+	if s := l.getState(); s != StateConnected {
+		log.Printf("cannot leave ring: %s", s)
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingState)
+		return
+	}
+	if len(l.ring) <= 2 {
+		// nobody to stay connected with
+		l.killRing()
+		return
+	}
+	port := l.getRandomPortName()
+	ring := orient(l.ring, l.myAddr, l.conns[port])
+	if ring == nil {
+		log.Printf("abnormal: I'm not in the ring %v", l.ring)
+		l.killRing()
+		return
+	}
+	m := &memberChange{
+		op:   memberLeave,
+		addr: l.myAddr,
+		ring: ring[1:],
+		id:   l.info.ID,
+	}
+	f, err := l.newFrame(broadcast, l.myAddr, memberFrame, m.marshal())
+	if err != nil {
+		log.Printf("cannot leave ring: %s", err)
+		l.killRing()
+		return
+	}
+	if err := l.setState(StateTearingDown, "leave"); err != nil {
+		log.Printf("cannot leave ring: %s", err)
+		l.SendActionStatusToApp(ERROR, "", "", ErrRingState)
+		return
+	}
+	l.sendToPort(port, f.Marshal())
+
+	// wait for the frame back, so all members know
+	l.expect(func() {
+		log.Printf("leave ring: member change frame hasn't come back")
+		l.left()
+	})
+}
+
+// left finishes OP_LEAVE_RING.
+func (l *layer) left() {
+	l.fulfil()
+	l.resetRing()
+	if err := l.setState(StateIdle, "left"); err != nil {
+		log.Printf("abnormal: %s", err)
+	}
+	l.SendMemberStatusToApp(memberLeave, l.info.ID, nil)
+}
+
+// gotMemberChange handles memberFrame which passes the ring.
+func (l *layer) gotMemberChange(f *frame, from string) {
+	m, err := parseMemberChange(f.data)
+	if err != nil {
+		log.Printf("got strange member frame %+v: %s", f, err)
+		return
+	}
+	if f.src == l.myAddr {
+		// went round the ring
+		if m.op == memberLeave {
+			if l.getState() != StateTearingDown {
+				log.Printf("abnormal: got leave frame back, but we don't listen for it...")
+				return
+			}
+			l.left()
+		}
+		return
+	}
+
+	i := 0
+	for i < len(m.ring) && m.ring[i] != l.myAddr {
+		i++
+	}
+	joined := m.op == memberJoin && l.myAddr == 0 && m.id == l.info.ID
+	if joined {
+		l.myAddr = m.addr
+		l.session = f.session
+		i = len(m.ring) - 1 // joined node is the last one
+	}
+	if i == len(m.ring) || m.ring[i] != l.myAddr {
+		log.Printf("got member frame of another ring: %+v", m)
+		return
+	}
+
+	other := l.getAnotherPort(from)
+	if other == "" {
+		log.Printf("member frame: disconnect, cannot find another port")
+		l.cannotPass()
+		return
+	}
+	l.forward(other, f)
+	l.applyMemberChange(m, i, from, other)
+
+	if joined {
+		l.joined()
+	}
+}
+
+// applyMemberChange updates neighbors of the node at i in the new ring.
+// Members tell about themselves again, so the joined node knows them.
+func (l *layer) applyMemberChange(m *memberChange, i int, prevPort, nextPort string) {
+	n := len(m.ring)
+	prev, next := m.ring[(i-1+n)%n], m.ring[(i+1)%n]
+	for port, a := range map[string]byte{prevPort: prev, nextPort: next} {
+		if old := l.conns[port]; old != a {
+			log.Printf("neighbor behind %s is changed: %d -> %d", port, old, a)
+			if m.op == memberLeave {
+				// link will be reconnected to the node behind the leaving one
+				l.SendActionStatusToApp(RELINK, port, strconv.Itoa(int(a)), "")
+			}
+		}
+		l.conns[port] = a
+	}
+	l.ring = m.ring
+
+	switch m.op {
+	case memberJoin:
+		l.announce()
+		l.announceGroups()
+	case memberLeave:
+		l.groups.removeAddr(m.addr)
+		if n, ok := l.roster.remove(m.addr); ok {
+			l.SendNodeStatusToApp(nodeLeave, n)
+		}
+	}
+	l.SendMemberStatusToApp(m.op, m.id, m.ring)
+}
@@ -0,0 +1,48 @@
+package datalayer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	cases := []struct {
+		ring     []byte
+		addr     byte
+		next     byte
+		expected []byte
+	}{
+		{ring: []byte{1, 2, 3, 4}, addr: 2, next: 3, expected: []byte{2, 3, 4, 1}},
+		{ring: []byte{1, 2, 3, 4}, addr: 2, next: 1, expected: []byte{2, 1, 4, 3}},
+		{ring: []byte{1, 5, 3}, addr: 1, next: 3, expected: []byte{1, 3, 5}},
+		{ring: []byte{1, 2, 3}, addr: 7, next: 1, expected: nil},
+	}
+
+	for i, c := range cases {
+		if got := orient(c.ring, c.addr, c.next); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("[%d] wrong ring: got %v, expected %v", i, got, c.expected)
+		}
+	}
+}
+
+func TestMemberChange(t *testing.T) {
+	m := &memberChange{
+		op:   memberJoin,
+		addr: 4,
+		ring: []byte{2, 3, 1, 4},
+		id:   "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+	}
+	got, err := parseMemberChange(m.marshal())
+	if err != nil {
+		t.Fatalf("cannot parse member change: %s", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("member changes don't match: got %+v, expected %+v", got, m)
+	}
+
+	for _, data := range [][]byte{nil, {memberJoin, 4}, {memberJoin, 4, 3, 1}, {0, 4, 0}} {
+		if _, err := parseMemberChange(data); err != ErrWrongMemberChange {
+			t.Errorf("wrong error for %v: got '%v', expected '%s'", data, err, ErrWrongMemberChange)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package datalayer
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+const broadcastDest = "broadcast" // backlog key of broadcast messages
+
+// outbox keeps outgoing messages, so app layer never waits for delivery.
+// Messages are queued by destination and every destination has at most one
+// message waiting for ACK: messages to one peer keep their order,
+// but a slow peer doesn't hold the others.
+// It is owned by the event loop of the layer, timers post to the loop.
+type outbox struct {
+	l        *layer
+	lastID   uint16                    // last assigned message id
+	queues   map[string][]SystemAction // by destination
+	inFlight map[string]*sentMessage   // by destination
+	kicked   bool                      // dispatch after the current event
+}
+
+type sentMessage struct {
+	msg     SystemAction
+	to      byte // resolved addr, ACK comes from it
+	timer   *time.Timer
+	attempt int      // broadcast is sent again for those who missed it
+	got     receipts // broadcast receipts of all attempts
+	back    bool     // broadcast went round the ring at least once
+}
+
+// QueueStatus is sent to app layer every time the outbox changes.
+type QueueStatus struct {
+	Depth   int            `json:"depth"`   // queued and not yet delivered messages
+	Backlog map[string]int `json:"backlog"` // the same by destination
+}
+
+func newOutbox(l *layer) *outbox {
+	return &outbox{
+		l:        l,
+		queues:   make(map[string][]SystemAction, 2),
+		inFlight: make(map[string]*sentMessage, 2),
+	}
+}
+
+func destOf(m SystemAction) string {
+	if m.Group != "" {
+		return "#" + m.Group
+	}
+	if m.Addr == "" {
+		return broadcastDest
+	}
+
+	return m.Addr
+}
+
+// push queues message and returns at once, id is assigned if message has no one.
+// Id of the app must not be queued or in flight, so statuses are not confused.
+func (o *outbox) push(m SystemAction) {
+	if m.ID == 0 {
+		for m.ID == 0 || o.used(m.ID) {
+			o.lastID++
+			m.ID = o.lastID
+		}
+	} else if o.used(m.ID) {
+		log.Printf("cannot queue message: id %d is in use", m.ID)
+		o.l.SendMessageStatusToApp(ERROR, m.ID, m.Addr, "%s", ErrMessageID)
+		return
+	}
+	dest := destOf(m)
+	o.queues[dest] = append(o.queues[dest], m)
+	qs := o.status()
+
+	log.Printf("queued message: %+v", m)
+	p := o.l.sentPayload(m)
+	o.l.remember(p)
+	o.l.toApp(PENDING, ActionPayload{ID: m.ID, To: m.Addr, ToPeer: p.ToPeer, Group: m.Group, Message: m.Message}) // lets frontend match assigned id
+	o.l.SendQueueStatusToApp(qs)
+	o.kick()
+}
+
+// used is true if message with id is queued or in flight.
+func (o *outbox) used(id uint16) bool {
+	for _, q := range o.queues {
+		for _, m := range q {
+			if m.ID == id {
+				return true
+			}
+		}
+	}
+	for _, sm := range o.inFlight {
+		if sm.msg.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kick asks the loop to dispatch messages when the current event is handled.
+func (o *outbox) kick() {
+	o.kicked = true
+}
+
+// dispatch sends the next message to every destination which has nothing in flight.
+// Nothing is sent while the ring is being changed: control operations go before data.
+func (o *outbox) dispatch() {
+	o.kicked = false
+	switch o.l.getState() {
+	case StateLinking, StateAwaitingLinkOK, StateDegraded, StateTearingDown:
+		return
+	}
+	var next []SystemAction
+	for dest, q := range o.queues {
+		if len(q) == 0 || o.inFlight[dest] != nil {
+			continue
+		}
+		m := q[0]
+		o.queues[dest] = q[1:]
+		sm := &sentMessage{msg: m}
+		o.startTimer(sm)
+		o.inFlight[dest] = sm
+		next = append(next, m)
+	}
+
+	for _, m := range next {
+		to, err := o.l.transmit(m, receipts{})
+		if err != nil {
+			log.Printf("cannot send message %d: %s", m.ID, err)
+			o.complete(destOf(m), m.ID, NO_ACK, err)
+			continue
+		}
+		o.inFlight[destOf(m)].to = to
+	}
+}
+
+func (o *outbox) startTimer(sm *sentMessage) {
+	id, attempt := sm.msg.ID, sm.attempt
+	if sm.timer != nil {
+		sm.timer.Stop()
+	}
+	sm.timer = o.l.after(o.l.sendWait, func() {
+		log.Printf("fail to send message %d: timeout", id)
+		if sm.msg.Addr == "" && sm.msg.Group == "" {
+			o.broadcastDone(id, attempt, nil)
+		} else {
+			o.complete(destOf(sm.msg), id, TIMEOUT, nil)
+		}
+	})
+}
+
+// broadcastBack handles broadcast frame which went round the ring.
+// It returns false if there is no such broadcast, e.g. it was timed out.
+func (o *outbox) broadcastBack(id uint16, got receipts) bool {
+	return o.broadcastDone(id, -1, &got)
+}
+
+// broadcastDone merges receipts of returned broadcast frame (got is nil on timeout
+// of the attempt) and broadcasts it again if somebody has missed it.
+// When attempts are over, it reports who got the message and who didn't.
+func (o *outbox) broadcastDone(id uint16, attempt int, got *receipts) bool {
+	sm := o.inFlight[broadcastDest]
+	if sm == nil || sm.msg.ID != id || (got == nil && sm.attempt != attempt) {
+		return false
+	}
+	if got != nil {
+		sm.got.merge(*got)
+		sm.back = true
+	}
+	delivered, missed := sm.got.split(o.l.ring, o.l.myAddr)
+	if (len(missed) != 0 || !sm.back) && sm.attempt < maxRebroadcasts {
+		sm.attempt++
+		o.startTimer(sm)
+		m, r := sm.msg, sm.got
+
+		log.Printf("broadcast %d: %v missed it, broadcast again", id, missed)
+		if _, err := o.l.transmit(m, r); err != nil {
+			log.Printf("cannot broadcast message %d again: %s", id, err)
+			o.complete(broadcastDest, id, NO_ACK, err)
+		}
+		return true
+	}
+	delete(o.inFlight, broadcastDest)
+	sm.timer.Stop()
+	qs := o.status()
+	o.l.req = sm.msg.Req
+
+	status := byte(ACK)
+	switch {
+	case !sm.back:
+		status = TIMEOUT
+	case len(missed) != 0:
+		status = NO_ACK
+	}
+	log.Printf("broadcast %d: got by %v, missed by %v", id, delivered, missed)
+	o.l.SendBroadcastStatusToApp(status, id, delivered, missed)
+	o.l.SendQueueStatusToApp(qs)
+	o.kick()
+
+	return true
+}
+
+// acked completes message to addr src which has got it.
+func (o *outbox) acked(src byte, id uint16) bool {
+	for dest, sm := range o.inFlight {
+		if dest != broadcastDest && sm.msg.Group == "" && sm.to == src && sm.msg.ID == id {
+			return o.complete(dest, id, ACK, nil)
+		}
+	}
+
+	return false
+}
+
+// groupBack completes group message which went round the ring.
+func (o *outbox) groupBack(id uint16) bool {
+	for dest, sm := range o.inFlight {
+		if sm.msg.Group != "" && sm.msg.ID == id {
+			return o.complete(dest, id, ACK, nil)
+		}
+	}
+
+	return false
+}
+
+// complete finishes in flight message to dest with status, err is the reason
+// of failure. It returns false if there is no such message, e.g. ACK came after timeout.
+func (o *outbox) complete(dest string, id uint16, status byte, err error) bool {
+	sm := o.inFlight[dest]
+	if sm == nil || sm.msg.ID != id {
+		return false
+	}
+	delete(o.inFlight, dest)
+	sm.timer.Stop()
+	qs := o.status()
+	o.l.req = sm.msg.Req
+
+	p := ActionPayload{ID: id, To: sm.msg.Addr}
+	if err != nil {
+		p.Message, p.Err = err.Error(), err
+	}
+	o.l.toApp(status, p)
+	o.l.SendQueueStatusToApp(qs)
+	o.kick()
+
+	return true
+}
+
+// abort fails queued and in flight messages with TIMEOUT, err is the reason.
+func (o *outbox) abort(err error) {
+	var failed []SystemAction
+	for dest, sm := range o.inFlight {
+		sm.timer.Stop()
+		failed = append(failed, sm.msg)
+		delete(o.inFlight, dest)
+	}
+	for dest, q := range o.queues {
+		failed = append(failed, q...)
+		delete(o.queues, dest)
+	}
+	if len(failed) == 0 {
+		return
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].ID < failed[j].ID })
+
+	for _, m := range failed {
+		log.Printf("fail to send message %d: %s", m.ID, err)
+		o.l.req = m.Req
+		o.l.toApp(TIMEOUT, ActionPayload{ID: m.ID, To: m.Addr, Group: m.Group, Message: err.Error(), Err: err})
+	}
+	o.l.req = ""
+	o.l.SendQueueStatusToApp(o.status())
+}
+
+// pending returns messages which are not delivered yet, in flight go first.
+func (o *outbox) pending() []ActionPayload {
+	var dests []string
+	for dest := range o.queues {
+		dests = append(dests, dest)
+	}
+	for dest := range o.inFlight {
+		if _, ok := o.queues[dest]; !ok {
+			dests = append(dests, dest)
+		}
+	}
+	sort.Strings(dests)
+
+	p := []ActionPayload{}
+	add := func(m SystemAction) {
+		p = append(p, ActionPayload{ID: m.ID, To: m.Addr, Group: m.Group, Message: m.Message})
+	}
+	for _, dest := range dests {
+		if sm := o.inFlight[dest]; sm != nil {
+			add(sm.msg)
+		}
+		for _, m := range o.queues[dest] {
+			add(m)
+		}
+	}
+
+	return p
+}
+
+func (o *outbox) status() *QueueStatus {
+	qs := &QueueStatus{
+		Backlog: make(map[string]int, len(o.queues)),
+	}
+	for dest, q := range o.queues {
+		if n := len(q); n != 0 {
+			qs.Backlog[dest] = n
+		}
+	}
+	for dest := range o.inFlight {
+		qs.Backlog[dest]++
+	}
+	for _, n := range qs.Backlog {
+		qs.Depth += n
+	}
+
+	return qs
+}
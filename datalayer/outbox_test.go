@@ -0,0 +1,106 @@
+package datalayer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"Pobeda/com"
+)
+
+// sentPorts is transport which only counts sent frames.
+type sentPorts struct {
+	sent int
+}
+
+func (p *sentPorts) Connect(cfg *com.Config) error { return nil }
+func (p *sentPorts) Close(name string) error       { return nil }
+func (p *sentPorts) Send(name string, data []byte) { p.sent++ }
+func (p *sentPorts) Chunks() <-chan *com.SendInfo  { return nil }
+
+func TestOutboxAck(t *testing.T) {
+	tr := &sentPorts{}
+	l := newLayer(64, "me", tr)
+	l.myAddr = 1
+	l.ring = ringOf(3)
+	l.conns["COM1"] = 2
+	l.conns["COM2"] = 3
+	l.roster.set(NodeInfo{Addr: 2, ID: "b"})
+
+	// the same id to both destinations is rejected, auto ids skip used ones
+	l.out.lastID = 4
+	l.out.push(SystemAction{ID: 5, Addr: "2", Message: "to 2"})
+	l.out.push(SystemAction{ID: 5, Addr: "3", Message: "to 3"})
+	l.out.push(SystemAction{Addr: "3", Message: "to 3"})
+	l.out.dispatch()
+	if tr.sent != 2 {
+		t.Fatalf("sent %d frames, expected 2", tr.sent)
+	}
+	var rejected bool
+	var ids []uint16
+	var peers []string
+	for len(l.GetAppC) != 0 {
+		a := <-l.GetAppC
+		p := a.Data.(ActionPayload)
+		switch a.AType {
+		case ERROR:
+			rejected = p.Message == ErrMessageID && p.ID == 5 && p.To == "3"
+		case PENDING:
+			ids = append(ids, p.ID)
+			peers = append(peers, p.ToPeer)
+		}
+	}
+	if len(peers) != 2 || peers[0] != "b" || peers[1] != "" {
+		t.Errorf("wrong peers %q, expected stable id of 2 only", peers)
+	}
+	if !rejected {
+		t.Error("taken id is not rejected")
+	}
+	if len(ids) != 2 || ids[0] != 5 || ids[1] != 6 {
+		t.Errorf("wrong ids %v, expected [5 6]", ids)
+	}
+
+	// ACK of 3 doesn't complete message 5 to 2
+	if l.out.acked(3, 5) {
+		t.Error("message 5 to 2 is acked by 3")
+	}
+	if !l.out.acked(3, 6) || !l.out.acked(2, 5) {
+		t.Error("messages are not acked")
+	}
+	if l.out.acked(2, 5) {
+		t.Error("message 5 is acked twice")
+	}
+	if len(l.out.inFlight) != 0 {
+		t.Errorf("in flight %+v", l.out.inFlight)
+	}
+}
+
+func TestOutboxNoRing(t *testing.T) {
+	l := newLayer(64, "me", &sentPorts{})
+	l.conns["COM1"] = 0
+
+	for _, m := range []SystemAction{{ID: 1, Message: "all"}, {ID: 2, Group: "g", Message: "to g"}} {
+		l.out.push(m)
+		l.out.dispatch()
+		var failed bool
+		for len(l.GetAppC) != 0 {
+			a := <-l.GetAppC
+			if p := a.Data.(ActionPayload); a.AType == NO_ACK {
+				failed = p.ID == m.ID && errors.Is(p.Err, ErrNoRing)
+			}
+		}
+		if !failed {
+			t.Errorf("message %d is not failed at once", m.ID)
+		}
+	}
+}
+
+func TestResultWait(t *testing.T) {
+	if w := ResultWait(0, 0); w != 20*time.Second {
+		t.Errorf("default wait is %s", w)
+	}
+	// broadcast with two rebroadcasts after the ring is linked
+	if w := ResultWait(time.Second, 7*time.Second); w != 22*time.Second {
+		t.Errorf("wait is %s, expected 22s", w)
+	}
+}
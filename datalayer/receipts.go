@@ -0,0 +1,37 @@
+package datalayer
+
+const receiptsLen = 16 // bit per addr, 128 bits cover all addrs
+
+// receipts is a bitmap of ring members who got broadcast frame,
+// it goes in front of broadcast data and is filled while frame passes the ring.
+type receipts [receiptsLen]byte
+
+func (r *receipts) set(addr byte) {
+	r[addr/8] |= 1 << (addr % 8)
+}
+
+func (r *receipts) has(addr byte) bool {
+	return r[addr/8]&(1<<(addr%8)) != 0
+}
+
+func (r *receipts) merge(o receipts) {
+	for i := range r {
+		r[i] |= o[i]
+	}
+}
+
+// split returns ring members except me who got and who missed the frame.
+func (r *receipts) split(ring []byte, me byte) (got, missed []int) {
+	for _, a := range ring {
+		if a == me {
+			continue
+		}
+		if r.has(a) {
+			got = append(got, int(a))
+		} else {
+			missed = append(missed, int(a))
+		}
+	}
+
+	return got, missed
+}
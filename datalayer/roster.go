@@ -0,0 +1,261 @@
+package datalayer
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	Version = "0.3.0"
+
+	maxNickLen = 32 // nickname bytes
+	maxHostLen = 32 // hostname bytes, announce frame has to fit max data len
+)
+
+// roster node events
+const (
+	nodeJoin   = "join"
+	nodeRename = "rename"
+	nodeUpdate = "update"
+	nodeLeave  = "leave"
+)
+
+// capabilities tell ring members which features this node supports.
+var capabilities = []string{"msg-id", "receipts", "groups", "routing"}
+
+// NodeInfo is what ring member tells about itself after ring connect.
+type NodeInfo struct {
+	Addr    byte     `json:"addr"`
+	ID      string   `json:"id,omitempty"` // stable, unlike addr
+	Nick    string   `json:"nick,omitempty"`
+	Host    string   `json:"host,omitempty"`
+	Version string   `json:"version,omitempty"`
+	Caps    []string `json:"caps,omitempty"`
+}
+
+// roster keeps ring members by addr, app layer reads it, so it's guarded.
+type roster struct {
+	mu    sync.RWMutex
+	nodes map[byte]NodeInfo
+}
+
+func newRoster() *roster {
+	return &roster{
+		nodes: make(map[byte]NodeInfo),
+	}
+}
+
+// set returns previous info of the node, if there was one.
+func (r *roster) set(n NodeInfo) (NodeInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.nodes[n.Addr]
+	r.nodes[n.Addr] = n
+
+	return old, ok
+}
+
+func (r *roster) nick(addr byte) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.nodes[addr].Nick
+}
+
+// remove returns info of the removed node, if there was one.
+func (r *roster) remove(addr byte) (NodeInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, ok := r.nodes[addr]
+	delete(r.nodes, addr)
+
+	return n, ok
+}
+
+// id returns stable id of the node with addr.
+func (r *roster) id(addr byte) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.nodes[addr].ID
+}
+
+// byID returns addr of the node with stable id.
+func (r *roster) byID(id string) (byte, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for a, n := range r.nodes {
+		if n.ID == id {
+			return a, true
+		}
+	}
+
+	return 0, false
+}
+
+// byNick returns addrs of the nodes with nickname.
+func (r *roster) byNick(nick string) []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var found []byte
+	for a, n := range r.nodes {
+		if n.Nick == nick {
+			found = append(found, a)
+		}
+	}
+
+	return found
+}
+
+// list returns nodes in ascending order of addrs.
+func (r *roster) list() []NodeInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	l := make([]NodeInfo, 0, len(r.nodes))
+	for _, n := range r.nodes {
+		l = append(l, n)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Addr < l[j].Addr })
+
+	return l
+}
+
+// reset forgets members of the ring which is gone.
+func (r *roster) reset() {
+	r.mu.Lock()
+	r.nodes = make(map[byte]NodeInfo)
+	r.mu.Unlock()
+}
+
+// Roster returns known ring members, app layer sends it to the new clients.
+func Roster() []NodeInfo {
+	return L.roster.list()
+}
+
+// validNick doesn't allow numbers, they are ring addrs.
+func validNick(nick string) bool {
+	if nick == "" || len(nick) > maxNickLen {
+		return false
+	}
+	_, err := strconv.ParseUint(nick, 10, 8)
+
+	return err != nil
+}
+
+// localInfo describes this node, hostname is the default nickname.
+func localInfo(id string) NodeInfo {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Printf("cannot get hostname: %s", err)
+	}
+	host = trimHost(host)
+	n := NodeInfo{
+		ID:      id,
+		Host:    host,
+		Version: Version,
+		Caps:    capabilities,
+	}
+	if validNick(host) {
+		n.Nick = host
+	}
+
+	return n
+}
+
+// trimHost cuts hostname to maxHostLen bytes at rune boundary.
+func trimHost(host string) string {
+	if len(host) <= maxHostLen {
+		return host
+	}
+	n := maxHostLen
+	for n > 0 && !utf8.RuneStart(host[n]) {
+		n--
+	}
+
+	return host[:n]
+}
+
+// announceData is announce payload of n, it has to fit the frame:
+// JSON escapes make it longer than the fields.
+func announceData(n NodeInfo) ([]byte, error) {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDataLen {
+		return nil, ErrDataTooLarge
+	}
+
+	return data, nil
+}
+
+// announce informs the ring about me, every member adds me to its roster.
+func (l *layer) announce() {
+	if l.myAddr == 0 {
+		return
+	}
+	l.info.Addr = l.myAddr
+	l.roster.set(l.info)
+	data, err := announceData(l.info)
+	if err != nil {
+		log.Printf("cannot announce: %s", err)
+		return
+	}
+	f, err := l.newFrame(broadcast, l.myAddr, announceFrame, data)
+	if err != nil {
+		log.Printf("cannot create announce frame: %s", err)
+		return
+	}
+	port := l.nextPort()
+	if port == "" {
+		log.Printf("cannot announce: no port available")
+		return
+	}
+	l.sendToPort(port, f.Marshal())
+}
+
+// gotAnnounce adds ring member to the roster.
+func (l *layer) gotAnnounce(f *frame) {
+	var n NodeInfo
+	if err := json.Unmarshal(f.data, &n); err != nil {
+		log.Printf("got strange announce frame %+v: %s", f, err)
+		return
+	}
+	if n.Nick != "" && !validNick(n.Nick) {
+		log.Printf("node %d has wrong nickname '%s'", f.src, n.Nick)
+		n.Nick = ""
+	}
+	n.Addr = f.src
+	event := nodeJoin
+	if old, ok := l.roster.set(n); ok {
+		event = nodeUpdate
+		if old.Nick != n.Nick {
+			event = nodeRename
+		}
+	}
+	l.SendNodeStatusToApp(event, n)
+}
+
+// setNick performs OP_SET_NICK.
+func (l *layer) setNick(nick string) {
+	if !validNick(nick) {
+		log.Printf("wrong nickname '%s'", nick)
+		l.SendActionStatusToApp(ERROR, "", nick, ErrNick)
+		return
+	}
+	n := l.info
+	n.Addr, n.Nick = maxAddr, nick // the longest addr
+	if _, err := announceData(n); err != nil {
+		log.Printf("nickname '%s' doesn't fit announce: %s", nick, err)
+		l.SendActionStatusToApp(ERROR, "", nick, ErrNick)
+		return
+	}
+	l.info.Nick = nick
+	l.announce()
+	l.SendNodeStatusToApp(nodeRename, l.info)
+}
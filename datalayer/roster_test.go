@@ -0,0 +1,35 @@
+package datalayer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetNick(t *testing.T) {
+	l := newLayer(16, "6ba7b810-9dad-11d1-80b4-00c04fd430c8", nil)
+	l.info.Nick = "bob"
+
+	// fits the length, but escaped announce doesn't fit the frame
+	l.setNick(strings.Repeat("<", maxNickLen))
+	if a := <-l.GetAppC; a.AType != ERROR || a.Data.(ActionPayload).Message != ErrNick {
+		t.Errorf("got %d %+v, expected ErrNick", a.AType, a.Data)
+	}
+	if l.info.Nick != "bob" {
+		t.Errorf("nick is changed to %s", l.info.Nick)
+	}
+
+	l.setNick("alice")
+	if a := <-l.GetAppC; a.AType != NODE || l.info.Nick != "alice" {
+		t.Errorf("got %d %+v, nick %s", a.AType, a.Data, l.info.Nick)
+	}
+}
+
+func TestTrimHost(t *testing.T) {
+	host := strings.Repeat("a", maxHostLen-1) + "ж"
+	if got := trimHost(host); got != strings.Repeat("a", maxHostLen-1) {
+		t.Errorf("got %q", got)
+	}
+	if got := trimHost("vm"); got != "vm" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,81 @@
+package datalayer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	ErrNoRing      = errors.New("ring is not connected")
+	ErrUnknownDest = errors.New("unknown destination")
+)
+
+// resolve finds ring addr of the destination given by ring addr, node id or nickname.
+func (l *layer) resolve(dest string) (byte, error) {
+	if l.myAddr == 0 {
+		return 0, ErrNoRing
+	}
+	if a, err := strconv.ParseUint(dest, 10, 8); err == nil {
+		if !l.isRingMember(byte(a)) {
+			return 0, fmt.Errorf("%w: no ring member with addr %d", ErrUnknownDest, a)
+		}
+		return byte(a), nil
+	}
+
+	if a, ok := l.roster.byID(dest); ok {
+		return a, nil
+	}
+
+	found := l.roster.byNick(dest)
+	switch len(found) {
+	case 0:
+		return 0, fmt.Errorf("%w: no ring member with nickname %q", ErrUnknownDest, dest)
+	case 1:
+		return found[0], nil
+	default:
+		return 0, fmt.Errorf("%w: nickname %q is used by %v, use addr", ErrUnknownDest, dest, found)
+	}
+}
+
+func (l *layer) isRingMember(addr byte) bool {
+	for _, a := range l.ring {
+		if a == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// route returns port to send frame to dest, frame goes the shorter way round the ring,
+// then every node passes it to another port.
+func (l *layer) route(dest byte) (string, error) {
+	if port := l.findPortNameByAddr(dest); port != "" {
+		return port, nil // neighbor
+	}
+
+	me, to := -1, -1
+	for i, a := range l.ring {
+		switch a {
+		case l.myAddr:
+			me = i
+		case dest:
+			to = i
+		}
+	}
+	if me == -1 || to == -1 {
+		return "", ErrUnknownDest
+	}
+	n := len(l.ring)
+	next := l.ring[(me+1)%n]
+	if forward := (to - me + n) % n; forward > n-forward {
+		next = l.ring[(me-1+n)%n] // backward is shorter
+	}
+	port := l.findPortNameByAddr(next)
+	if port == "" {
+		return "", fmt.Errorf("no port to the neighbor %d", next)
+	}
+
+	return port, nil
+}
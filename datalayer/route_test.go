@@ -0,0 +1,51 @@
+package datalayer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLayer_Route(t *testing.T) {
+	// me is 3 in the ring of 6, port "up" goes to 4, "down" goes to 2
+	l := newLayer(1, "", nil)
+	l.myAddr = 3
+	l.ring = ringOf(6)
+	l.conns["up"] = 4
+	l.conns["down"] = 2
+	l.roster.set(NodeInfo{Addr: 5, Nick: "alice", ID: "6ba7b810-9dad-11d1-80b4-00c04fd430c8"})
+	l.roster.set(NodeInfo{Addr: 6, Nick: "bob"})
+	l.roster.set(NodeInfo{Addr: 1, Nick: "bob"})
+
+	cases := []struct {
+		dest     string
+		expected string
+		err      bool
+	}{
+		{dest: "4", expected: "up"},
+		{dest: "2", expected: "down"},
+		{dest: "alice", expected: "up"},
+		{dest: "6ba7b810-9dad-11d1-80b4-00c04fd430c8", expected: "up"},
+		{dest: "6", expected: "up"}, // both ways are of 3 hops
+		{dest: "1", expected: "down"},
+		{dest: "7", err: true},
+		{dest: "carol", err: true},
+		{dest: "bob", err: true}, // ambiguous
+	}
+
+	for i, c := range cases {
+		var port string
+		addr, err := l.resolve(c.dest)
+		if err == nil {
+			port, err = l.route(addr)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("[%d] wrong error for %s: got '%v', expected error: %t", i, c.dest, err, c.err)
+		}
+		if err != nil && !errors.Is(err, ErrUnknownDest) {
+			t.Errorf("[%d] error for %s is not ErrUnknownDest: %v", i, c.dest, err)
+		}
+		if port != c.expected {
+			t.Errorf("[%d] wrong port for %s: got '%s', expected '%s'", i, c.dest, port, c.expected)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package datalayer
+
+// seeds are hand-made chunks of ring connect and messaging, there are no
+// captures of a real ring. They are cases of the decoder and fuzz seeds.
+var seeds = [][]byte{
+	{0xff, 0x7f, 0x01, 0x01, 0x4e, 0x21, 0x00, 0x00, 0x04, 0x01, 0x01, 0xff},                    // link frame from the initiator
+	{0xff, 0x7f, 0x01, 0x01, 0x4e, 0x21, 0x00, 0x00, 0x04, 0x01, 0x02, 0xff},                    // link frame passed by the 2nd computer
+	{0xff, 0x7f, 0x01, 0x02, 0x4e, 0x21, 0x00, 0x00, 0x04, 0x00, 0xff},                          // link ok frame
+	{0xff, 0x02, 0x01, 0x00, 0x4e, 0x21, 0x00, 0x01, 0x04, 0x05, 'h', 'e', 'l', 'l', 'o', 0xff}, // message
+	{0xff, 0x01, 0x02, 0x04, 0x4e, 0x21, 0x00, 0x01, 0x04, 0x00, 0xff},                          // ack
+	{0xff, 0x7f, 0x03, 0x00, 0x4e, 0x21, 0x00, 0x01, 0x04, 0x03, 'y', 'o', '!', 0xff},           // broadcast message
+	{0xff, 0x00, 0x02, 0x03, 0x4e, 0x21, 0x00, 0x00, 0x04, 0x00, 0xff},                          // uplink frame
+	{0xff, 0x02, 0x01, 0x00, 0x4e, 0x21, 0x00, 0x01, 0x04, 0x05, 'h', 'e'},                      // first chunk of the message
+	{'l', 'l', 'o', 0xff}, // second chunk of the message
+	{0xff, 0x7f, 0x01, 0x02, 0x4e, 0x21, 0x00, 0x00, 0x04, 0x00, 0xff, 0xff, 0x00, 0x02, 0x03, 0x4e, 0x21, 0x00}, // two frames in one chunk, last is cut
+	{0x00, 0x00, 0xff, 0x7f, 0x01, 0x02, 0x4e, 0x21, 0x00, 0x00, 0x04, 0x00, 0xff},                               // line noise before the frame
+}
@@ -0,0 +1,82 @@
+package datalayer
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	sessionMu   sync.Mutex // layers of simulated nodes share it
+	sessionRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Stats are counters for diagnostics.
+type Stats struct {
+	StaleFrames  uint64 `json:"staleFrames"`  // frames of another ring session
+	PurgedFrames uint64 `json:"purgedFrames"` // orphaned frames which are out of hops
+}
+
+// GetStats returns counters of data layer.
+func GetStats() Stats {
+	return L.getStats()
+}
+
+func (l *layer) getStats() Stats {
+	return Stats{
+		StaleFrames:  atomic.LoadUint64(&l.stats.StaleFrames),
+		PurgedFrames: atomic.LoadUint64(&l.stats.PurgedFrames),
+	}
+}
+
+// newSession returns random id of the new ring, which differs from the current one.
+func (l *layer) newSession() uint16 {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	for {
+		s := uint16(sessionRand.Intn(1 << 16))
+		if s != 0 && s != l.session && s != l.linkSession {
+			return s
+		}
+	}
+}
+
+// inSession tells if the frame belongs to my ring. Frames which start
+// the new ring have no session yet.
+func (l *layer) inSession(f *frame) bool {
+	switch f.fType {
+	case linkFrame, electFrame, joinFrame:
+		return true
+	case linkOKFrame:
+		return l.pending[f.session] != nil || (l.session != 0 && f.session == l.session)
+	}
+	if l.myAddr == 0 && l.joining {
+		return true // session of the ring is not known yet
+	}
+
+	return l.session != 0 && f.session == l.session
+}
+
+// dropStale counts frame of another ring session.
+func (l *layer) dropStale(f *frame) {
+	atomic.AddUint64(&l.stats.StaleFrames, 1)
+	log.Printf("drop frame of another ring session %d (mine is %d): %+v", f.session, l.session, f)
+}
+
+// passThrough tells that I'm not a ring member, but frames of the ring go
+// through me, e.g. I'm joining or I've left and the link is not reconnected yet.
+func (l *layer) passThrough(f *frame) bool {
+	if l.myAddr != 0 {
+		return false
+	}
+	switch f.fType {
+	case linkFrame, linkOKFrame, electFrame, joinFrame:
+		return false
+	case memberFrame:
+		return !l.joining
+	}
+
+	return true
+}
@@ -0,0 +1,215 @@
+package datalayer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Pobeda/com"
+)
+
+// simNet is null modem cables between ports of the simulated computers.
+type simNet struct {
+	mu    sync.Mutex
+	cable map[string]string // "node/port" of both ends
+	ports map[string]*simPorts
+}
+
+func newSimNet() *simNet {
+	return &simNet{
+		cable: make(map[string]string),
+		ports: make(map[string]*simPorts),
+	}
+}
+
+func (n *simNet) plug(a, b string) {
+	n.cable[a] = b
+	n.cable[b] = a
+}
+
+// simPorts is transport of the simulated computer.
+type simPorts struct {
+	net    *simNet
+	node   string
+	open   map[string]bool // guarded by net.mu
+	chunks chan *com.SendInfo
+}
+
+func (p *simPorts) Connect(cfg *com.Config) error {
+	p.net.mu.Lock()
+	defer p.net.mu.Unlock()
+	if _, ok := p.net.cable[p.node+"/"+cfg.Name]; !ok {
+		return errors.New("no cable")
+	}
+	p.open[cfg.Name] = true
+
+	return nil
+}
+
+func (p *simPorts) Close(name string) error {
+	p.net.mu.Lock()
+	defer p.net.mu.Unlock()
+	if !p.open[name] {
+		return com.ErrConnNotFound
+	}
+	delete(p.open, name)
+
+	return nil
+}
+
+// Send splits data into two chunks, like serial port does.
+func (p *simPorts) Send(name string, data []byte) {
+	p.net.mu.Lock()
+	var peer *simPorts
+	to := strings.SplitN(p.net.cable[p.node+"/"+name], "/", 2)
+	if len(to) == 2 && p.open[name] && p.net.ports[to[0]].open[to[1]] {
+		peer = p.net.ports[to[0]]
+	}
+	p.net.mu.Unlock()
+	if peer == nil {
+		return // cable is not connected
+	}
+	half := len(data) / 2
+	for _, chunk := range [][]byte{data[:half], data[half:]} {
+		peer.chunks <- &com.SendInfo{Name: to[1], Data: append([]byte{}, chunk...)}
+	}
+}
+
+func (p *simPorts) Chunks() <-chan *com.SendInfo {
+	return p.chunks
+}
+
+type simNode struct {
+	id     string
+	l      *layer
+	events chan *Action
+}
+
+func (n *simNet) start(id string) *simNode {
+	p := &simPorts{
+		net:    n,
+		node:   id,
+		open:   make(map[string]bool, 2),
+		chunks: make(chan *com.SendInfo, 1024),
+	}
+	n.mu.Lock()
+	n.ports[id] = p
+	n.mu.Unlock()
+
+	node := &simNode{
+		id:     id,
+		l:      newLayer(queueLen, id, p),
+		events: make(chan *Action, 1024),
+	}
+	node.l.start(context.Background())
+	go func() {
+		for a := range node.l.GetAppC {
+			node.events <- a
+		}
+		close(node.events)
+	}()
+
+	return node
+}
+
+func (n *simNode) do(op byte, sa SystemAction) {
+	n.l.SendAppC <- &Action{AType: op, Data: sa}
+}
+
+// wait skips events until the one of aType which matches.
+func (n *simNode) wait(t *testing.T, aType byte, match func(p ActionPayload) bool) ActionPayload {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case a := <-n.events:
+			p, ok := a.Data.(ActionPayload)
+			if ok && a.AType == aType && (match == nil || match(p)) {
+				return p
+			}
+		case <-timeout:
+			t.Fatalf("node %s: no event %d in time", n.id, aType)
+		}
+	}
+}
+
+// TestSimRing runs the ring of 3 computers in one process, it's for go test -race.
+func TestSimRing(t *testing.T) {
+	net := newSimNet()
+	net.plug("a/1", "b/0")
+	net.plug("b/1", "c/0")
+	net.plug("c/1", "a/0")
+	nodes := []*simNode{net.start("a"), net.start("b"), net.start("c")}
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	for _, n := range nodes {
+		for _, port := range []string{"0", "1"} {
+			n.do(OP_CONNECT, SystemAction{Cfg: &com.Config{Name: port}})
+			n.wait(t, CONNECT, nil)
+		}
+	}
+
+	a.do(OP_RING_CONNECT, SystemAction{})
+	for _, n := range nodes {
+		n.wait(t, CONNECT_RING, nil)
+		if s := n.l.getState(); s != StateConnected {
+			t.Fatalf("node %s: wrong state %s", n.id, s)
+		}
+	}
+
+	// unicast by node id, it's known from the announce
+	a.wait(t, NODE, func(p ActionPayload) bool { return p.Node.ID == "c" })
+	a.do(OP_SEND, SystemAction{ID: 7, Addr: "c", Message: "hello"})
+	c.wait(t, MESSAGE, func(p ActionPayload) bool { return p.Message == "hello" && p.Peer == "a" })
+	a.wait(t, ACK, func(p ActionPayload) bool { return p.ID == 7 })
+
+	// port which is not open keeps the ring
+	a.do(OP_DISCONNECT, SystemAction{Addr: "5"})
+	a.wait(t, ERROR, func(p ActionPayload) bool { return p.Message == ErrNotOpen && p.Addr == "5" })
+	if s := a.l.getState(); s != StateConnected {
+		t.Fatalf("wrong state %s after disconnect of not open port", s)
+	}
+
+	// the ring waits for the lost port
+	a.do(OP_DISCONNECT, SystemAction{Addr: "1"})
+	a.wait(t, RING_STATE, func(p ActionPayload) bool { return p.State.To == StateDegraded.String() })
+	a.do(OP_CONNECT, SystemAction{Cfg: &com.Config{Name: "1"}})
+	a.wait(t, RING_STATE, func(p ActionPayload) bool { return p.State.To == StateConnected.String() })
+
+	// broadcast
+	b.do(OP_SEND, SystemAction{ID: 8, Message: "all"})
+	for _, n := range []*simNode{a, c} {
+		n.wait(t, MESSAGE, func(p ActionPayload) bool { return p.Message == "all" })
+	}
+	got := b.wait(t, ACK, func(p ActionPayload) bool { return p.ID == 8 })
+	if len(got.Delivered) != 2 || len(got.Missed) != 0 {
+		t.Errorf("broadcast receipts: delivered %v, missed %v", got.Delivered, got.Missed)
+	}
+
+	b.do(OP_KILL_RING, SystemAction{})
+	for _, n := range nodes {
+		n.wait(t, RING_STATE, func(p ActionPayload) bool { return p.State.To == StateIdle.String() })
+		n.wait(t, DISRUPTION, nil)
+	}
+
+	// shutdown kills the ring
+	a.do(OP_RING_CONNECT, SystemAction{})
+	for _, n := range nodes {
+		n.wait(t, CONNECT_RING, nil)
+	}
+	for _, n := range nodes {
+		if err := n.l.shutdown(context.Background()); err != nil {
+			t.Errorf("node %s: shutdown: %s", n.id, err)
+		}
+		for range n.events {
+		}
+		if n == a {
+			for _, other := range []*simNode{b, c} {
+				other.wait(t, DISRUPTION, nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package datalayer
+
+import (
+	"sort"
+	"strconv"
+
+	"Pobeda/com"
+)
+
+const historyLen = 50 // last messages in snapshot
+
+// Snapshot is everything app layer needs to show the node from scratch,
+// e.g. after browser reconnect. Events after it are incremental.
+type Snapshot struct {
+	Seq       uint64          `json:"seq"`       // of the last event included, set by app layer
+	ID        string          `json:"id"`        // mine, stable
+	Ports     []com.Config    `json:"ports"`     // open ports by name
+	State     string          `json:"state"`     // of the ring
+	Addr      int             `json:"addr"`      // mine, 0 if there is no ring
+	Ring      []int           `json:"ring"`      // addrs in the order of link frame pass
+	Neighbors map[string]int  `json:"neighbors"` // addrs behind the ports
+	Roster    []NodeInfo      `json:"roster"`
+	Groups    []string        `json:"groups"` // I'm member of
+	Auto      bool            `json:"auto"`
+	Pending   []ActionPayload `json:"pending"`  // queued and in flight messages
+	Messages  []ActionPayload `json:"messages"` // last got and sent messages, oldest first
+}
+
+// remember keeps the message for snapshot.
+func (l *layer) remember(p ActionPayload) {
+	p.Req = ""
+	l.history = append(l.history, p)
+	if len(l.history) > historyLen {
+		l.history = l.history[len(l.history)-historyLen:]
+	}
+}
+
+func (l *layer) snapshot() *Snapshot {
+	s := &Snapshot{
+		Ports:     make([]com.Config, 0, len(l.ports)),
+		ID:        l.info.ID,
+		State:     l.getState().String(),
+		Addr:      int(l.myAddr),
+		Ring:      make([]int, 0, len(l.ring)),
+		Neighbors: make(map[string]int, len(l.conns)),
+		Roster:    l.roster.list(),
+		Groups:    make([]string, 0, len(l.groups.mine)),
+		Auto:      l.auto,
+		Pending:   l.out.pending(),
+		Messages:  append([]ActionPayload{}, l.history...),
+	}
+	for _, cfg := range l.ports {
+		s.Ports = append(s.Ports, *cfg)
+	}
+	sort.Slice(s.Ports, func(i, j int) bool { return s.Ports[i].Name < s.Ports[j].Name })
+	for _, a := range l.ring {
+		s.Ring = append(s.Ring, int(a))
+	}
+	for port, a := range l.conns {
+		if a != 0 {
+			s.Neighbors[port] = int(a)
+		}
+	}
+	for name := range l.groups.mine {
+		s.Groups = append(s.Groups, name)
+	}
+	sort.Strings(s.Groups)
+
+	return s
+}
+
+// sentPayload is how my message looks in the history.
+func (l *layer) sentPayload(m SystemAction) ActionPayload {
+	return ActionPayload{
+		ID:      m.ID,
+		Addr:    strconv.Itoa(int(l.myAddr)),
+		Nick:    l.info.Nick,
+		Peer:    l.info.ID,
+		Message: m.Message,
+		To:      m.Addr,
+		ToPeer:  l.peerOf(m.Addr),
+		Group:   m.Group,
+	}
+}
+
+// peerOf returns stable id of the destination, empty for broadcast and unknown one.
+func (l *layer) peerOf(dest string) string {
+	if dest == "" {
+		return ""
+	}
+	a, err := l.resolve(dest)
+	if err != nil {
+		return ""
+	}
+
+	return l.roster.id(a)
+}
@@ -0,0 +1,63 @@
+package datalayer
+
+import (
+	"reflect"
+	"testing"
+
+	"Pobeda/com"
+)
+
+func TestSnapshot(t *testing.T) {
+	l := newLayer(64, "me", nil)
+	l.myAddr = 2
+	l.ring = []byte{1, 2, 3}
+	l.ports["COM2"] = &com.Config{Name: "COM2", BaudRate: 9600}
+	l.ports["COM1"] = &com.Config{Name: "COM1", BaudRate: 9600}
+	l.conns["COM1"] = 1
+	l.conns["COM2"] = 3
+	l.groups.mine["b"] = true
+	l.groups.mine["a"] = true
+
+	l.sendMessageToApp(7, 1, "", "hi")
+	l.out.push(SystemAction{Addr: "3", Message: "hello"})
+	l.out.push(SystemAction{Addr: "3", Message: "again"})
+	for i := 0; i < historyLen; i++ {
+		l.sendMessageToApp(uint16(i), 3, "", "spam")
+	}
+	l.control(OP_SNAPSHOT, SystemAction{})
+
+	var s *Snapshot
+	for len(l.GetAppC) != 0 {
+		if a := <-l.GetAppC; a.AType == SNAPSHOT {
+			s = a.Data.(ActionPayload).Snapshot
+		}
+	}
+	if s == nil {
+		t.Fatal("no snapshot")
+	}
+	if len(s.Ports) != 2 || s.Ports[0].Name != "COM1" || s.Ports[1].Name != "COM2" {
+		t.Errorf("wrong ports %+v", s.Ports)
+	}
+	if s.Addr != 2 || !reflect.DeepEqual(s.Ring, []int{1, 2, 3}) {
+		t.Errorf("wrong addr %d or ring %v", s.Addr, s.Ring)
+	}
+	if !reflect.DeepEqual(s.Neighbors, map[string]int{"COM1": 1, "COM2": 3}) {
+		t.Errorf("wrong neighbors %v", s.Neighbors)
+	}
+	if !reflect.DeepEqual(s.Groups, []string{"a", "b"}) {
+		t.Errorf("wrong groups %v", s.Groups)
+	}
+	expected := []ActionPayload{
+		{ID: 1, To: "3", Message: "hello"},
+		{ID: 2, To: "3", Message: "again"},
+	}
+	if !reflect.DeepEqual(s.Pending, expected) {
+		t.Errorf("wrong pending %+v, expected %+v", s.Pending, expected)
+	}
+	if len(s.Messages) != historyLen {
+		t.Fatalf("got %d messages, expected last %d", len(s.Messages), historyLen)
+	}
+	if last := s.Messages[historyLen-1]; last.ID != historyLen-1 || last.Message != "spam" {
+		t.Errorf("wrong last message %+v", last)
+	}
+}
@@ -0,0 +1,151 @@
+package datalayer
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// RingState is the state of the node in the ring.
+type RingState byte
+
+const (
+	StateIdle           RingState = iota // not a ring member
+	StateLinking                         // I'm initiator, link frame goes round the ring
+	StateAwaitingLinkOK                  // link frame is passed or join is asked, wait for the ring
+	StateConnected                       // ring member
+	StateDegraded                        // ring member, but a neighbor port is lost, it may come back
+	StateTearingDown                     // ring is being killed or left
+)
+
+var stateNames = [...]string{"idle", "linking", "awaiting_link_ok", "connected", "degraded", "tearing_down"}
+
+func (s RingState) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+
+	return fmt.Sprintf("state(%d)", s)
+}
+
+// transitions are legal moves of the state machine:
+//
+//	idle -> linking: OP_RING_CONNECT
+//	idle -> awaiting_link_ok: link frame of another initiator is passed, OP_JOIN_RING
+//	linking -> connected: my link frame is back
+//	linking -> awaiting_link_ok: link frame of another initiator wins
+//	linking, awaiting_link_ok -> idle: timeout
+//	awaiting_link_ok -> connected: link ok frame, member frame of my join
+//	connected -> degraded: neighbor port is lost
+//	degraded -> connected: lost port is connected again in link wait
+//	connected, degraded -> tearing_down: OP_KILL_RING, OP_LEAVE_RING, uplink frame
+//	tearing_down -> idle: ring is forgotten
+var transitions = map[RingState][]RingState{
+	StateIdle:           {StateLinking, StateAwaitingLinkOK},
+	StateLinking:        {StateConnected, StateAwaitingLinkOK, StateIdle},
+	StateAwaitingLinkOK: {StateConnected, StateIdle},
+	StateConnected:      {StateDegraded, StateTearingDown},
+	StateDegraded:       {StateConnected, StateTearingDown},
+	StateTearingDown:    {StateIdle},
+}
+
+// TransitionError is returned on illegal move of the state machine.
+type TransitionError struct {
+	From, To RingState
+	Reason   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("illegal ring state transition %s -> %s (%s)", e.From, e.To, e.Reason)
+}
+
+// StateChange is sent to app layer on every transition.
+type StateChange struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+// ringState is changed by both app and phys layers.
+type ringState struct {
+	mu    sync.Mutex
+	state RingState
+}
+
+func canTransit(from, to RingState) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setState moves the state machine and publishes the transition.
+func (l *layer) setState(to RingState, reason string) error {
+	l.st.mu.Lock()
+	from := l.st.state
+	if !canTransit(from, to) {
+		l.st.mu.Unlock()
+		return &TransitionError{From: from, To: to, Reason: reason}
+	}
+	l.st.state = to
+	l.st.mu.Unlock()
+
+	log.Printf("ring state: %s -> %s (%s)", from, to, reason)
+	l.SendStateToApp(from, to, reason)
+	l.out.kick() // messages wait while the ring is being changed
+
+	return nil
+}
+
+func (l *layer) getState() RingState {
+	l.st.mu.Lock()
+	defer l.st.mu.Unlock()
+
+	return l.st.state
+}
+
+// State returns the ring state of the node.
+func State() RingState {
+	return L.getState()
+}
+
+// degrade keeps the ring whose neighbor port is lost for link wait: the ring
+// goes on if the port is connected again, else it is killed.
+func (l *layer) degrade(port string, neighbor byte) {
+	if err := l.setState(StateDegraded, "port "+port+" is lost"); err != nil {
+		log.Printf("degrade: %s", err)
+		return
+	}
+	l.lostPort, l.lostAddr = port, neighbor
+	l.expect(func() {
+		if l.getState() != StateDegraded {
+			return
+		}
+		log.Printf("port %s is not back, kill the ring", port)
+		l.lostPort = ""
+		l.killRing()
+	})
+}
+
+// relink restores the degraded ring, its lost port is connected again.
+func (l *layer) relink() {
+	l.fulfil()
+	l.conns[l.lostPort] = l.lostAddr
+	l.lostPort = ""
+	if err := l.setState(StateConnected, "lost port is back"); err != nil {
+		log.Printf("abnormal: %s", err)
+	}
+}
+
+// cannotPass handles frame which cannot be passed, the next port is lost.
+// The degraded ring waits for the port, another one is killed.
+func (l *layer) cannotPass() {
+	if l.getState() == StateDegraded {
+		return // sender of the frame times out
+	}
+	l.SendActionStatusToApp(DISCONNECT, l.lastDead, "", "")
+	l.killRing()
+}
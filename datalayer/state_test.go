@@ -0,0 +1,73 @@
+package datalayer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetState(t *testing.T) {
+	l := newLayer(16, "", nil)
+	steps := []struct {
+		to    RingState
+		legal bool
+	}{
+		{to: StateConnected, legal: false},
+		{to: StateLinking, legal: true},
+		{to: StateAwaitingLinkOK, legal: true}, // another initiator wins
+		{to: StateConnected, legal: true},
+		{to: StateLinking, legal: false},
+		{to: StateDegraded, legal: true},
+		{to: StateConnected, legal: true}, // lost port is back
+		{to: StateLinking, legal: false},
+		{to: StateDegraded, legal: true},
+		{to: StateTearingDown, legal: true},
+		{to: StateIdle, legal: true},
+	}
+
+	for i, s := range steps {
+		from := l.getState()
+		err := l.setState(s.to, "test")
+		if s.legal != (err == nil) {
+			t.Fatalf("[%d] %s -> %s: got error %v, expected legal %t", i, from, s.to, err, s.legal)
+		}
+		if err != nil {
+			if _, ok := err.(*TransitionError); !ok {
+				t.Errorf("[%d] wrong error type %T", i, err)
+			}
+			if got := l.getState(); got != from {
+				t.Errorf("[%d] state is changed by illegal transition: %s", i, got)
+			}
+			continue
+		}
+		a := <-l.GetAppC
+		sc := a.Data.(ActionPayload).State
+		if a.AType != RING_STATE || sc == nil || sc.From != from.String() || sc.To != s.to.String() {
+			t.Errorf("[%d] wrong transition event %+v", i, a)
+		}
+	}
+}
+
+func TestDegrade(t *testing.T) {
+	l := newLayer(64, "me", &sentPorts{})
+	l.linkWait = time.Millisecond
+	l.myAddr = 1
+	l.ring = ringOf(3)
+	l.conns["COM1"] = 2
+	l.conns["COM2"] = 3
+	for _, s := range []RingState{StateLinking, StateConnected} {
+		if err := l.setState(s, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// frames which cannot be passed are dropped while the port may come back
+	l.control(OP_DISCONNECT, SystemAction{Addr: "COM2"})
+	l.cannotPass()
+	if s := l.getState(); s != StateDegraded {
+		t.Fatalf("state is %s, expected degraded", s)
+	}
+	(<-l.events)()
+	if s := l.getState(); s != StateIdle {
+		t.Errorf("state is %s after link wait, expected idle", s)
+	}
+}
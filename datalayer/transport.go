@@ -0,0 +1,35 @@
+package datalayer
+
+import (
+	"Pobeda/com"
+)
+
+// transport is the physical layer under the data layer.
+type transport interface {
+	Connect(cfg *com.Config) error
+	Close(name string) error
+	Send(name string, data []byte)
+	Chunks() <-chan *com.SendInfo // read from all ports
+}
+
+// comTransport is com ports of the computer.
+type comTransport struct{}
+
+func (comTransport) Connect(cfg *com.Config) error {
+	return com.Connect(cfg)
+}
+
+func (comTransport) Close(name string) error {
+	return com.ClosePort(name)
+}
+
+func (comTransport) Send(name string, data []byte) {
+	com.L.SendC <- &com.SendInfo{
+		Name: name,
+		Data: data,
+	}
+}
+
+func (comTransport) Chunks() <-chan *com.SendInfo {
+	return com.L.GotC
+}
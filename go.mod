@@ -1,10 +1,10 @@
 module Pobeda
 
-go 1.12
+go 1.18
 
 require (
 	github.com/gorilla/websocket v1.4.0
 	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4
 	github.com/satori/go.uuid v1.2.0
-	golang.org/x/sys v0.0.0-20190509141414-a5b02f93d862 // indirect
+	golang.org/x/sys v0.0.0-20190509141414-a5b02f93d862
 )
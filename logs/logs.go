@@ -0,0 +1,52 @@
+// Package logs sets up the standard logger of the node: output and level.
+package logs
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// levels
+const (
+	LevelDebug = "debug" // frames and chunks too
+	LevelInfo  = "info"
+	LevelOff   = "off"
+)
+
+// Debug enables Debugf, it's set once at startup.
+var Debug bool
+
+// Debugf logs per frame details, they are too many for info level.
+func Debugf(format string, a ...interface{}) {
+	if Debug {
+		log.Printf(format, a...)
+	}
+}
+
+// Setup switches logger to the level and the file, empty file is stderr.
+// Returned closer closes the file.
+func Setup(level, file string) (io.Closer, error) {
+	var c io.Closer = io.NopCloser(nil)
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		log.SetOutput(f)
+		c = f
+	}
+	switch level {
+	case LevelDebug:
+		Debug = true
+	case LevelInfo, "":
+	case LevelOff:
+		log.SetOutput(io.Discard)
+	default:
+		c.Close()
+		return nil, fmt.Errorf("unknown log level %s", level)
+	}
+
+	return c, nil
+}
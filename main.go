@@ -2,19 +2,27 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"Pobeda/applayer"
 	"Pobeda/com"
+	"Pobeda/config"
+	"Pobeda/ctl"
 	"Pobeda/datalayer"
-)
-
-const (
-	srvPort = ":8000"
+	"Pobeda/logs"
+	"Pobeda/tui"
+	"Pobeda/web"
 )
 
 func main() {
@@ -24,35 +32,284 @@ func main() {
 	// 	BaudRate: 115200,
 	// }))
 
-	com.Init()
-	defer com.Close()
-	datalayer.Init()
-	defer datalayer.Close()
-	applayer.Init()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "tui":
+			runTUI(os.Args[2:])
+			return
+		case "ctl":
+			runCtl(os.Args[2:])
+			return
+		}
+	}
 
-	// init application layer and start listen to it
-	srv := http.Server{
-		Addr: srvPort,
+	cfg, _ := parseConfig("pobeda", os.Args[1:])
+	logFile, err := logs.Setup(cfg.Log.Level, cfg.Log.File)
+	if err != nil {
+		log.Fatalf("cannot set up logs: %s", err)
 	}
-	http.HandleFunc("/ws", applayer.Connect)
+	defer logFile.Close()
 
+	n := startNode(cfg)
 	idleConnsClosed := make(chan struct{})
 	go func() {
 		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 		sig := <-sigs
 
-		log.Printf("got %s, shutting down server", sig)
-		if err := srv.Shutdown(context.Background()); err != nil {
-			log.Printf("HTTP server Shutdown: %s", err)
-		}
+		log.Printf("got %s, shutting down", sig)
+		stopNode(n, time.Duration(cfg.Timeouts.Shutdown))
 		close(idleConnsClosed)
 	}()
 
-	log.Printf("Starting HTTP server on %s...", srvPort)
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+	serve(n)
+	<-idleConnsClosed
+}
+
+// parseConfig exits on wrong flags or config file.
+// It returns arguments after flags.
+func parseConfig(name string, args []string) (*config.Config, []string) {
+	cfg, args, err := config.Parse(name, args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return cfg, args
+}
+
+// node is HTTP servers of app layer: srv for browsers and network clients,
+// ctl for `pobeda ctl` on the control socket, it's nil if the socket can't be opened.
+type node struct {
+	srv  *http.Server
+	srvL net.Listener // bound by the caller, srv listens on its Addr if nil
+	ctl  *http.Server
+	ctlL net.Listener
+}
+
+// startNode starts layers of the node and returns its servers to serve.
+// Configured ports are opened and the ring is formed by the auto ring policy.
+func startNode(cfg *config.Config) *node {
+	id, err := datalayer.LoadIdentity(datalayer.DefaultStateDir())
+	if err != nil {
+		log.Fatalf("cannot load node identity: %s", err)
+	}
+	log.Printf("node id is %s", id)
+
+	// layers start from the bottom and stop from the top
+	ctx := context.Background()
+	com.Init(ctx, cfg.Queues.Com)
+	datalayer.Init(ctx, id, datalayer.Options{
+		QueueLen: cfg.Queues.Data,
+		LinkWait: time.Duration(cfg.Timeouts.Link),
+		SendWait: time.Duration(cfg.Timeouts.Send),
+	})
+	applayer.Init(ctx, applayer.Options{
+		QueueLen:   cfg.Queues.App,
+		Auth:       authOf(cfg),
+		ResultWait: datalayer.ResultWait(time.Duration(cfg.Timeouts.Link), time.Duration(cfg.Timeouts.Send)),
+	})
+	startup(cfg)
+
+	// init application layer and start listen to it
+	srv := &http.Server{
+		Addr: cfg.Listen,
+	}
+	http.HandleFunc("/ws", applayer.Connect)
+	http.Handle("/api/", applayer.API())
+	http.HandleFunc("/events", applayer.Events)
+	if !cfg.Daemon {
+		http.Handle("/", web.Handler())
+	}
+	srv.RegisterOnShutdown(applayer.Close) // server doesn't track websockets and SSE streams
+	n := &node{srv: srv}
+
+	// the same ops as REST API, file permissions restrict them to the owner
+	if n.ctlL, err = ctl.Listen(ctl.SocketPath(cfg)); err != nil {
+		log.Printf("cannot open control socket: %s", err)
+		return n
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/", applayer.API())
+	mux.HandleFunc("/events", applayer.Events)
+	n.ctl = &http.Server{Handler: applayer.Trusted(mux)}
+	n.ctl.RegisterOnShutdown(applayer.Close)
+
+	return n
+}
+
+// authOf makes access policy of app layer, operator token is generated
+// if it isn't configured.
+func authOf(cfg *config.Config) applayer.Auth {
+	if cfg.Auth.Token == "" {
+		token, err := applayer.LoadToken(datalayer.DefaultStateDir())
+		if err != nil {
+			log.Fatalf("cannot load operator token: %s", err)
+		}
+		cfg.Auth.Token = token
+	} else {
+		log.Printf("operator token is configured")
+	}
+	a := applayer.Auth{
+		Origins: cfg.Auth.Origins,
+		Tokens:  map[string]string{cfg.Auth.Token: applayer.RoleOperator},
+	}
+	if cfg.Auth.ObserverToken != "" {
+		a.Tokens[cfg.Auth.ObserverToken] = applayer.RoleObserver
+	}
+	switch cfg.Auth.Anonymous {
+	case config.AnonymousObserver:
+		a.Anonymous = applayer.RoleObserver
+	case config.AnonymousOperator:
+		a.Anonymous = applayer.RoleOperator
+	}
+
+	return a
+}
+
+// startup queues actions of the config, data layer performs them in order,
+// so the ring is joined when the ports are open. Failures come to clients and the log.
+func startup(cfg *config.Config) {
+	if cfg.Nick != "" {
+		datalayer.GetActionStatusFromApp("", datalayer.OP_SET_NICK, "", nil, cfg.Nick)
+	}
+	for i := range cfg.Ports {
+		datalayer.GetActionStatusFromApp("", datalayer.OP_CONNECT, "", &cfg.Ports[i], "")
+	}
+	switch cfg.AutoRing {
+	case config.AutoRingJoin:
+		// if there is no working ring, join fails and the ring is elected
+		datalayer.GetActionStatusFromApp("", datalayer.OP_JOIN_RING, "", nil, "")
+		datalayer.GetAutoRingFromApp("", true)
+	case config.AutoRingElect:
+		datalayer.GetAutoRingFromApp("", true)
+	}
+}
+
+func serve(n *node) {
+	if n.ctl != nil {
+		log.Printf("Control socket is %s", n.ctlL.Addr())
+		go func() {
+			if err := n.ctl.Serve(n.ctlL); err != http.ErrServerClosed {
+				log.Printf("control socket Serve: %s", err)
+			}
+		}()
+	}
+	if n.srvL != nil {
+		log.Printf("Starting HTTP server on %s...", n.srvL.Addr())
+		if err := n.srv.Serve(n.srvL); err != http.ErrServerClosed {
+			log.Printf("HTTP server Serve: %s", err)
+		}
+		return
+	}
+	log.Printf("Starting HTTP server on %s...", n.srv.Addr)
+	if err := n.srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Printf("HTTP server ListenAndServe: %s", err)
 	}
+}
+
+// stopNode waits up to wait to tell the ring I'm leaving and write queued frames.
+func stopNode(n *node, wait time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	// uplink frame tells the ring that I'm leaving, it must be written before ports are closed
+	if err := datalayer.Shutdown(ctx); err != nil {
+		log.Printf("data layer Shutdown: %s", err)
+	}
+	if err := com.Drain(ctx); err != nil {
+		log.Printf("com Drain: %s", err)
+	}
+	com.Close()
 
-	<-idleConnsClosed
+	log.Printf("shutting down server")
+	if n.ctl != nil {
+		if err := n.ctl.Shutdown(ctx); err != nil {
+			log.Printf("control socket Shutdown: %s", err)
+		}
+	}
+	if err := n.srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server Shutdown: %s", err)
+	}
+}
+
+// runTUI is `pobeda tui [flags] [URL]`: without URL it drives the node in process
+// over an ephemeral loopback port, otherwise it connects to the running node,
+// e.g. ws://host:8000/ws.
+// Operator token is -token or the one of the local node.
+// Logs go to the state dir by default, they would break the screen.
+func runTUI(args []string) {
+	cfg, args := parseConfig("pobeda tui", args)
+	if cfg.Log.File == "" {
+		dir := datalayer.DefaultStateDir()
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Fatalf("cannot create state dir: %s", err)
+		}
+		cfg.Log.File = filepath.Join(dir, "tui.log")
+	}
+	f, err := logs.Setup(cfg.Log.Level, cfg.Log.File)
+	if err != nil {
+		log.Fatalf("cannot set up logs: %s", err)
+	}
+	defer f.Close()
+
+	var url string
+	var n *node
+	if len(args) != 0 {
+		url = args[0]
+		if cfg.Auth.Token == "" {
+			// the node may run on this host
+			b, err := ioutil.ReadFile(filepath.Join(datalayer.DefaultStateDir(), applayer.TokenFile))
+			if err == nil {
+				cfg.Auth.Token = strings.TrimSpace(string(b))
+			}
+		}
+	} else {
+		// -listen may be taken by another node, the TUI must not talk to it
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			log.SetOutput(os.Stderr)
+			log.Fatalf("cannot listen on loopback: %s", err)
+		}
+		url = localURL(l.Addr().String())
+		n = startNode(cfg) // sets generated token
+		n.srvL = l
+		go serve(n)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+	err = tui.Run(ctx, url, cfg.Auth.Token)
+	if n != nil {
+		stopNode(n, time.Duration(cfg.Timeouts.Shutdown))
+	}
+	if err != nil {
+		log.SetOutput(os.Stderr)
+		log.Fatal(err)
+	}
+}
+
+// localURL is websocket endpoint of the node listening on addr of this host.
+func localURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "ws://" + addr + "/ws"
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+	}
+
+	return "ws://" + net.JoinHostPort(host, port) + "/ws"
+}
+
+// runCtl is `pobeda ctl [flags] COMMAND`: admin command to the running node,
+// see ctl package.
+func runCtl(args []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := ctl.Run(ctx, args, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "pobeda ctl: %s\n", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,93 @@
+package tui
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"Pobeda/com"
+)
+
+const defaultBaudRate = 9600
+
+var errQuit = errors.New("quit")
+
+// request is websocket protocol v2 request.
+type request struct {
+	Op      string      `json:"op"`
+	ID      string      `json:"id,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+type message struct {
+	Addr    string `json:"addr,omitempty"`
+	Message string `json:"message"`
+}
+
+// parseInput makes request of the command line, errQuit means the user quits:
+//
+//	text                  broadcast message
+//	@dest text            message to ring addr, node id or nickname
+//	/connect NAME [BAUD]  connect to the port
+//	/disconnect NAME      disconnect from the port
+//	/ring, /kill          connect or kill the ring
+//	/join, /leave         join or leave the working ring
+//	/nick NAME            set my nickname
+//	/quit
+func parseInput(s string) (*request, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("nothing to send")
+	}
+	if strings.HasPrefix(s, "@") {
+		f := strings.SplitN(s[1:], " ", 2)
+		if len(f) != 2 || f[0] == "" || strings.TrimSpace(f[1]) == "" {
+			return nil, errors.New("usage: @dest text")
+		}
+		return &request{Op: "send", Payload: message{Addr: f[0], Message: strings.TrimSpace(f[1])}}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return &request{Op: "send", Payload: message{Message: s}}, nil
+	}
+
+	f := strings.Fields(s)
+	switch f[0] {
+	case "/connect":
+		if len(f) < 2 || len(f) > 3 {
+			return nil, errors.New("usage: /connect NAME [BAUD]")
+		}
+		cfg := com.Config{Name: f[1], BaudRate: defaultBaudRate, Size: 8, Parity: "none", StopBits: 1}
+		if len(f) == 3 {
+			baud, err := strconv.ParseUint(f[2], 10, 32)
+			if err != nil || baud == 0 {
+				return nil, errors.New("wrong baud rate " + f[2])
+			}
+			cfg.BaudRate = uint(baud)
+		}
+		return &request{Op: "connect", Payload: cfg}, nil
+	case "/disconnect":
+		if len(f) != 2 {
+			return nil, errors.New("usage: /disconnect NAME")
+		}
+		return &request{Op: "disconnect", Payload: message{Addr: f[1]}}, nil
+	case "/ring":
+		return &request{Op: "ring_connect"}, nil
+	case "/kill":
+		return &request{Op: "kill_ring"}, nil
+	case "/join":
+		return &request{Op: "join_ring"}, nil
+	case "/leave":
+		return &request{Op: "leave_ring"}, nil
+	case "/nick":
+		if len(f) != 2 {
+			return nil, errors.New("usage: /nick NAME")
+		}
+		return &request{Op: "set_nick", Payload: struct {
+			Nick string `json:"nick"`
+		}{f[1]}}, nil
+	case "/quit":
+		return nil, errQuit
+	}
+
+	return nil, errors.New("unknown command " + f[0])
+}
@@ -0,0 +1,184 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"Pobeda/com"
+	"Pobeda/datalayer"
+)
+
+const maxLines = 500 // of conversation
+
+// event is websocket protocol v2 event, the same the web frontend gets.
+type event struct {
+	Event   string                  `json:"event"`
+	Seq     uint64                  `json:"seq,omitempty"`
+	ID      string                  `json:"id,omitempty"`
+	Payload datalayer.ActionPayload `json:"payload"`
+	Error   *struct {
+		Code    string            `json:"code"`
+		Message string            `json:"message"`
+		Details map[string]string `json:"details,omitempty"`
+	} `json:"error,omitempty"`
+}
+
+// line is a message of the conversation, mine have delivery status.
+type line struct {
+	id     uint16
+	mine   bool
+	text   string
+	status string
+}
+
+// model is what the screen shows, it's changed by events of the node.
+// It starts from snapshot and applies broadcast events after it by seq,
+// resync is true when a new snapshot is needed.
+type model struct {
+	online    bool
+	seq       uint64
+	synced    bool
+	resync    bool
+	me        string // stable id
+	addr      int
+	state     string
+	ports     []com.Config
+	neighbors map[string]int
+	roster    []datalayer.NodeInfo
+	lines     []line
+	notice    string // last error or info
+}
+
+// apply changes model by event, it returns false if the event is dropped.
+func (m *model) apply(e *event) bool {
+	if e.Event == "snapshot" && e.Payload.Snapshot != nil {
+		m.applySnapshot(e.Payload.Snapshot)
+		return true
+	}
+	if e.Seq != 0 {
+		if !m.synced || e.Seq <= m.seq {
+			return false // waiting for snapshot or included in it
+		}
+		if e.Seq != m.seq+1 {
+			m.synced, m.resync = false, true
+			return false
+		}
+		m.seq = e.Seq
+	}
+
+	p := e.Payload
+	switch e.Event {
+	case "error":
+		if e.Error != nil {
+			m.notice = "error: " + e.Error.Message + details(e.Error.Details)
+		}
+	case "ring_state":
+		m.state = p.Message
+	case "message":
+		m.addLine(p, false)
+	case "pending":
+		if m.find(p.ID) == nil {
+			m.addLine(datalayer.ActionPayload{ID: p.ID, To: p.To, Message: p.Message}, true)
+		}
+	case "ack", "no_ack", "timeout":
+		if l := m.find(p.ID); l != nil {
+			l.status = strings.Replace(e.Event, "_", " ", 1)
+			if len(p.Missed) != 0 {
+				l.status += fmt.Sprintf(", missed by %v", p.Missed)
+			}
+		}
+	case "connect", "disconnect", "connect_ring", "disruption", "ring_change", "relink", "node", "roster":
+		m.resync = true // ports, addrs and roster are changed
+	}
+
+	return true
+}
+
+func (m *model) applySnapshot(s *datalayer.Snapshot) {
+	m.seq, m.synced, m.resync = s.Seq, true, false
+	m.me = s.ID
+	m.addr = s.Addr
+	m.state = s.State
+	m.ports = s.Ports
+	m.neighbors = s.Neighbors
+	m.roster = s.Roster
+	sort.Slice(m.roster, func(i, j int) bool { return m.roster[i].Addr < m.roster[j].Addr })
+
+	pending := make(map[uint16]bool, len(s.Pending))
+	for _, p := range s.Pending {
+		pending[p.ID] = true
+	}
+	m.lines = m.lines[:0]
+	for _, p := range s.Messages {
+		mine := p.Peer == m.me
+		m.addLine(p, mine)
+		if mine && !pending[p.ID] {
+			m.lines[len(m.lines)-1].status = "sent"
+		}
+	}
+	for _, p := range s.Pending {
+		if m.find(p.ID) == nil {
+			m.addLine(p, true)
+		}
+	}
+}
+
+func (m *model) addLine(p datalayer.ActionPayload, mine bool) {
+	from := p.Nick
+	if from == "" {
+		from = p.Addr
+	}
+	to := ""
+	switch {
+	case p.Group != "":
+		to = " #" + p.Group
+	case mine && p.To != "":
+		to = " -> " + p.To
+	}
+	if mine {
+		from = "me"
+	}
+	l := line{id: p.ID, mine: mine, text: from + to + ": " + p.Message}
+	if mine {
+		l.status = "pending"
+	}
+	m.lines = append(m.lines, l)
+	if len(m.lines) > maxLines {
+		m.lines = m.lines[len(m.lines)-maxLines:]
+	}
+}
+
+// find returns my message with id.
+func (m *model) find(id uint16) *line {
+	for i := len(m.lines) - 1; i >= 0; i-- {
+		if m.lines[i].mine && m.lines[i].id == id {
+			return &m.lines[i]
+		}
+	}
+
+	return nil
+}
+
+func details(d map[string]string) string {
+	if len(d) == 0 {
+		return ""
+	}
+	var kv []string
+	for k, v := range d {
+		kv = append(kv, k+": "+v)
+	}
+	sort.Strings(kv)
+
+	return " (" + strings.Join(kv, ", ") + ")"
+}
+
+func decodeEvent(raw []byte) (*event, error) {
+	e := &event{}
+	if err := json.Unmarshal(raw, e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
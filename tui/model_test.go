@@ -0,0 +1,102 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"Pobeda/com"
+)
+
+func TestModelApply(t *testing.T) {
+	var m model
+	steps := []struct {
+		raw     string
+		applied bool
+	}{
+		{raw: `{"event":"message","seq":1,"payload":{"message":"before snapshot"}}`, applied: false},
+		{raw: `{"event":"snapshot","id":"tui-1","payload":{"snapshot":{"seq":1,"id":"me","state":"Connected","addr":2,
+			"ports":[{"name":"COM1","baudRate":9600}],"neighbors":{"COM1":1},"roster":[{"addr":2,"nick":"bob"},{"addr":1}],
+			"messages":[{"id":1,"peer":"me","message":"hi","to":"1"}],"pending":[{"id":2,"message":"yo"}]}}}`, applied: true},
+		{raw: `{"event":"message","seq":1,"payload":{"message":"included in snapshot"}}`, applied: false},
+		{raw: `{"event":"message","seq":2,"payload":{"addr":"1","message":"hello"}}`, applied: true},
+		{raw: `{"event":"ack","id":"tui-2","payload":{"id":2}}`, applied: true},
+		{raw: `{"event":"error","id":"tui-3","error":{"code":"ring_state","message":"op is illegal","details":{"to":"x"}}}`, applied: true},
+		{raw: `{"event":"message","seq":4,"payload":{"message":"after gap"}}`, applied: false},
+	}
+	for i, s := range steps {
+		e, err := decodeEvent([]byte(s.raw))
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if got := m.apply(e); got != s.applied {
+			t.Errorf("[%d] applied %t, expected %t", i, got, s.applied)
+		}
+	}
+
+	if !m.resync {
+		t.Errorf("gap in seq doesn't ask for snapshot")
+	}
+	expected := []line{
+		{id: 1, mine: true, text: "me -> 1: hi", status: "sent"},
+		{id: 2, mine: true, text: "me: yo", status: "ack"},
+		{text: "1: hello"},
+	}
+	if len(m.lines) != len(expected) {
+		t.Fatalf("got lines %+v, expected %+v", m.lines, expected)
+	}
+	for i := range expected {
+		if m.lines[i] != expected[i] {
+			t.Errorf("[%d] got line %+v, expected %+v", i, m.lines[i], expected[i])
+		}
+	}
+	if m.notice != "error: op is illegal (to: x)" {
+		t.Errorf("wrong notice %q", m.notice)
+	}
+
+	rows := render(&m, "typing", 80, 10)
+	if len(rows) != 10 {
+		t.Fatalf("got %d rows, expected 10", len(rows))
+	}
+	if !strings.Contains(rows[0], "ring: Connected | addr: 2") {
+		t.Errorf("wrong header %q", rows[0])
+	}
+	if !strings.Contains(rows[2], "COM1 9600 -> 1") {
+		t.Errorf("wrong ports pane %q", rows[2])
+	}
+	if rows[9] != "> typing" {
+		t.Errorf("wrong input line %q", rows[9])
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected request
+		err      bool
+	}{
+		{input: "hello all", expected: request{Op: "send", Payload: message{Message: "hello all"}}},
+		{input: "@bob hi there", expected: request{Op: "send", Payload: message{Addr: "bob", Message: "hi there"}}},
+		{input: "/connect COM1", expected: request{Op: "connect", Payload: com.Config{Name: "COM1", BaudRate: 9600, Size: 8, Parity: "none", StopBits: 1}}},
+		{input: "/connect COM2 115200", expected: request{Op: "connect", Payload: com.Config{Name: "COM2", BaudRate: 115200, Size: 8, Parity: "none", StopBits: 1}}},
+		{input: "/disconnect COM1", expected: request{Op: "disconnect", Payload: message{Addr: "COM1"}}},
+		{input: "/ring", expected: request{Op: "ring_connect"}},
+		{input: "/kill", expected: request{Op: "kill_ring"}},
+		{input: "/connect COM1 fast", err: true},
+		{input: "@bob", err: true},
+		{input: "/unknown", err: true},
+		{input: "  ", err: true},
+	}
+	for _, c := range cases {
+		r, err := parseInput(c.input)
+		if c.err != (err != nil) {
+			t.Errorf("%q: got error %v, expected error %t", c.input, err, c.err)
+			continue
+		}
+		if err == nil && *r != c.expected {
+			t.Errorf("%q: got %+v, expected %+v", c.input, *r, c.expected)
+		}
+	}
+	if _, err := parseInput("/quit"); err != errQuit {
+		t.Errorf("/quit doesn't quit: %v", err)
+	}
+}
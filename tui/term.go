@@ -0,0 +1,59 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package tui
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// term switches the terminal to raw mode: keys come one by one without echo,
+// the screen is drawn by ANSI escape sequences.
+type term struct {
+	fd  int
+	old unix.Termios
+}
+
+func rawTerm(fd int) (*term, error) {
+	old, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+
+	return &term{fd: fd, old: *old}, nil
+}
+
+// notifyResize sends to c when the terminal is resized.
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
+
+// restore returns the terminal to the mode it was before.
+func (t *term) restore() error {
+	return unix.IoctlSetTermios(t.fd, ioctlSetTermios, &t.old)
+}
+
+// size returns columns and rows of the terminal.
+func (t *term) size() (int, int) {
+	ws, err := unix.IoctlGetWinsize(t.fd, unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 80, 24
+	}
+
+	return int(ws.Col), int(ws.Row)
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+// term is not supported here, Run fails before drawing anything.
+type term struct{}
+
+func rawTerm(fd int) (*term, error) {
+	return nil, errors.New("raw terminal not supported")
+}
+
+func notifyResize(c chan<- os.Signal) {}
+
+func (t *term) restore() error {
+	return nil
+}
+
+func (t *term) size() (int, int) {
+	return 80, 24
+}
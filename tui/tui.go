@@ -0,0 +1,215 @@
+// Package tui is the terminal client of the node for headless machines.
+// It talks websocket protocol v2, so it shows the same events as the web frontend.
+package tui
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+const redialWait = 2 * time.Second
+
+// keys
+const (
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlK     = 11
+	keyEnter     = 13
+	keyCtrlP     = 16
+	keyCtrlR     = 18
+	keyCtrlX     = 24
+	keyEsc       = 27
+	keyBackspace = 127
+	keyCtrlH     = 8
+)
+
+// Run shows the node at url (websocket endpoint) until the user quits or ctx is done.
+// The node may be gone and come back, it's dialed again. Without token the node
+// may take the user for observer.
+func Run(ctx context.Context, url, token string) error {
+	t, err := rawTerm(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("cannot switch terminal to raw mode: %s", err)
+	}
+	defer func() {
+		fmt.Print(home)
+		if err := t.restore(); err != nil {
+			log.Printf("cannot restore terminal: %s", err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conns := make(chan *websocket.Conn)
+	events := make(chan []byte, 32)
+	go dial(ctx, url, token, conns, events)
+	keys := make(chan byte, 32)
+	go readKeys(keys)
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	defer signal.Stop(resized)
+
+	var (
+		m     model
+		conn  *websocket.Conn
+		input []byte
+		esc   bool // skip escape sequence of arrows and function keys
+		reqs  int
+	)
+	send := func(r *request) {
+		if conn == nil {
+			m.notice = "node is offline"
+			return
+		}
+		reqs++
+		r.ID = fmt.Sprintf("tui-%d", reqs)
+		if err := conn.WriteJSON(r); err != nil {
+			m.notice = "cannot send request: " + err.Error()
+		}
+	}
+	for {
+		if m.resync && conn != nil {
+			m.resync = false
+			send(&request{Op: "snapshot"})
+		}
+		w, h := t.size()
+		fmt.Print(home + strings.Join(render(&m, string(input), w, h), "\r\n"))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-resized:
+		case c := <-conns:
+			conn, m.online = c, c != nil
+			if c == nil {
+				m.synced = false
+			}
+		case raw := <-events:
+			e, err := decodeEvent(raw)
+			if err != nil {
+				log.Printf("cannot decode event: %s", err)
+				continue
+			}
+			m.apply(e)
+		case k := <-keys:
+			if esc {
+				esc = !(k >= 'A' && k <= 'Z' || k >= 'a' && k <= 'z' || k == '~')
+				continue
+			}
+			switch k {
+			case keyCtrlC, keyCtrlD:
+				return nil
+			case keyEsc:
+				esc = true
+			case keyCtrlP:
+				input = []byte("/connect ")
+			case keyCtrlX:
+				input = []byte("/disconnect ")
+			case keyCtrlR:
+				send(&request{Op: "ring_connect"})
+			case keyCtrlK:
+				send(&request{Op: "kill_ring"})
+			case keyBackspace, keyCtrlH:
+				_, size := utf8.DecodeLastRune(input)
+				input = input[:len(input)-size]
+			case keyEnter:
+				r, err := parseInput(string(input))
+				if err == errQuit {
+					return nil
+				}
+				if err != nil {
+					m.notice = err.Error()
+					continue
+				}
+				m.notice = ""
+				input = input[:0]
+				send(r)
+			default:
+				if k >= ' ' {
+					input = append(input, k) // rune is drawn when all its bytes come
+				}
+			}
+		}
+	}
+}
+
+// dial connects to the node and passes its events, nil conn means the node is offline.
+func dial(ctx context.Context, url, token string, conns chan<- *websocket.Conn, events chan<- []byte) {
+	d := websocket.Dialer{Subprotocols: []string{"pobeda.v2"}}
+	h := http.Header{}
+	if token != "" {
+		h.Set("Authorization", "Bearer "+token)
+	}
+	for {
+		conn, resp, err := d.Dial(url, h)
+		if err != nil && resp != nil {
+			log.Printf("cannot dial %s: %s", url, resp.Status) // e.g. wrong token
+		}
+		if err == nil {
+			select {
+			case conns <- conn:
+			case <-ctx.Done():
+				conn.Close()
+				return
+			}
+			read(ctx, conn, events)
+			conn.Close()
+			select {
+			case conns <- nil:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-time.After(redialWait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func read(ctx context.Context, conn *websocket.Conn, events chan<- []byte) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close() // unblocks ReadMessage
+		case <-done:
+		}
+	}()
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		select {
+		case events <- raw:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readKeys passes bytes of stdin, multibyte runes come byte by byte.
+func readKeys(keys chan<- byte) {
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			keys <- b
+		}
+	}
+}
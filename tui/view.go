@@ -0,0 +1,122 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	leftWidth = 30 // of ports and roster pane
+
+	help = "^P connect port  ^X disconnect port  ^R ring connect  ^K kill ring  Enter send  ^C quit"
+
+	// ANSI escape sequences
+	home    = "\x1b[H\x1b[2J"
+	reverse = "\x1b[7m"
+	red     = "\x1b[31m"
+	reset   = "\x1b[0m"
+)
+
+// render draws the model in w columns and h rows, input is the command line.
+func render(m *model, input string, w, h int) []string {
+	if h < 4 {
+		h = 4
+	}
+	rows := make([]string, 0, h)
+
+	node := "offline"
+	if m.online {
+		node = "online"
+	}
+	addr := "-"
+	if m.addr != 0 {
+		addr = fmt.Sprint(m.addr)
+	}
+	header := fmt.Sprintf(" Pobeda | node: %s | ring: %s | addr: %s", node, m.state, addr)
+	rows = append(rows, reverse+pad(header, w)+reset)
+
+	lw := leftWidth
+	if lw > w/3 {
+		lw = w / 3
+	}
+	left := m.leftPane()
+	right := m.conversation(h - 3)
+	for i := 0; i < h-3; i++ {
+		var l, r string
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		rows = append(rows, pad(l, lw)+"|"+cut(r, w-lw-1))
+	}
+
+	if m.notice != "" {
+		rows = append(rows, red+pad(m.notice, w)+reset)
+	} else {
+		rows = append(rows, pad(help, w))
+	}
+	rows = append(rows, cut("> "+input, w))
+
+	return rows
+}
+
+func (m *model) leftPane() []string {
+	l := []string{" Ports"}
+	for _, p := range m.ports {
+		s := fmt.Sprintf("  %s %d", p.Name, p.BaudRate)
+		if a := m.neighbors[p.Name]; a != 0 {
+			s += fmt.Sprintf(" -> %d", a)
+		}
+		l = append(l, s)
+	}
+	l = append(l, "", " Roster")
+	for _, n := range m.roster {
+		s := fmt.Sprintf("  %d %s", n.Addr, n.Nick)
+		if int(n.Addr) == m.addr {
+			s += " (me)"
+		}
+		l = append(l, s)
+	}
+
+	return l
+}
+
+// conversation returns the last lines which fit in h rows.
+func (m *model) conversation(h int) []string {
+	var rows []string
+	for _, l := range m.lines {
+		s := " " + l.text
+		if l.mine {
+			s += " [" + l.status + "]"
+		}
+		rows = append(rows, s)
+	}
+	if len(rows) > h {
+		rows = rows[len(rows)-h:]
+	}
+
+	return rows
+}
+
+// pad cuts or fills s with spaces up to w runes.
+func pad(s string, w int) string {
+	s = cut(s, w)
+	if n := len([]rune(s)); n < w {
+		s += strings.Repeat(" ", w-n)
+	}
+
+	return s
+}
+
+func cut(s string, w int) string {
+	if w <= 0 {
+		return ""
+	}
+	if r := []rune(s); len(r) > w {
+		return string(r[:w])
+	}
+
+	return s
+}
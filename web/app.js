@@ -0,0 +1,316 @@
+'use strict';
+
+// UI talks websocket protocol v2: named ops, request ids and error objects.
+// It starts from snapshot and applies broadcast events after it by seq,
+// a gap in seq asks for a new snapshot.
+
+const $ = (id) => document.getElementById(id);
+
+let ws = null;
+let lastReq = 0;
+let seq = -1; // of the last applied broadcast event, -1 until snapshot
+let me = {addr: 0, peer: ''};
+const messages = new Map(); // my messages by id for delivery status
+const peers = new Map(); // roster by stable id, addrs change after re-ring
+const convs = new Set(['all']); // conversations: stable id of the peer, #group or all
+let current = 'all';
+let denied = 0; // HTTP status of the refused connection, it's told once
+
+// operator token comes once in ?token= of the page URL and is kept by the browser,
+// without it the node rejects the user unless anonymous role is configured
+const token = (() => {
+  const q = new URLSearchParams(location.search);
+  if (q.has('token')) {
+    localStorage.setItem('pobeda.token', q.get('token'));
+    history.replaceState(null, '', location.pathname);
+  }
+  return localStorage.getItem('pobeda.token') || '';
+})();
+
+function request(op, payload) {
+  if (!ws || ws.readyState !== WebSocket.OPEN) {
+    toast('not connected to the node');
+    return;
+  }
+  lastReq++;
+  ws.send(JSON.stringify({op: op, id: 'ui-' + lastReq, payload: payload || {}}));
+}
+
+function connect() {
+  const scheme = location.protocol === 'https:' ? 'wss://' : 'ws://';
+  const query = token ? '?token=' + encodeURIComponent(token) : '';
+  ws = new WebSocket(scheme + location.host + '/ws' + query, ['pobeda.v2']);
+  let opened = false;
+  ws.onopen = () => {
+    opened = true;
+    denied = 0;
+    setLink(true);
+  };
+  ws.onclose = () => {
+    setLink(false);
+    seq = -1;
+    if (!opened) {
+      checkAccess(query);
+    }
+    setTimeout(connect, 2000);
+  };
+  ws.onmessage = (e) => handle(JSON.parse(e.data));
+}
+
+// checkAccess tells why the websocket is refused, the browser hides the HTTP status
+// of the handshake, so REST API is asked the same way.
+function checkAccess(query) {
+  fetch('/api/status' + query).then((r) => {
+    if ((r.status !== 401 && r.status !== 403) || r.status === denied) {
+      return;
+    }
+    denied = r.status;
+    toast(r.status === 401
+      ? 'token is needed: open the page with ?token= from the token file of the node'
+      : 'access is denied: the token or origin is not allowed');
+  }).catch(() => {});
+}
+
+function setLink(on) {
+  $('link').textContent = on ? 'online' : 'offline';
+  $('link').className = on ? 'badge' : 'badge off';
+}
+
+function handle(e) {
+  if (e.event === 'snapshot') {
+    applySnapshot(e.payload.snapshot);
+    return;
+  }
+  if (e.seq) {
+    if (seq < 0 || e.seq <= seq) {
+      return; // waiting for snapshot or included in it
+    }
+    if (e.seq !== seq + 1) {
+      seq = -1;
+      request('snapshot');
+      return;
+    }
+    seq = e.seq;
+  }
+
+  const p = e.payload || {};
+  switch (e.event) {
+    case 'error':
+      toast(e.error.message + details(e.error.details));
+      break;
+    case 'ring_state':
+      $('state').textContent = p.message;
+      break;
+    case 'message':
+      addMessage(p, false);
+      break;
+    case 'pending':
+      if (!messages.has(p.id)) {
+        const m = {id: p.id, message: p.message, to: p.to, toPeer: p.toPeer, group: p.group, peer: me.peer};
+        addMessage(m, true);
+        select(convOf(m, true));
+      }
+      break;
+    case 'ack':
+    case 'no_ack':
+    case 'timeout':
+      setStatus(p.id, e.event, p);
+      break;
+    case 'connect':
+    case 'disconnect':
+    case 'connect_ring':
+    case 'disruption':
+    case 'ring_change':
+    case 'relink':
+    case 'node':
+    case 'roster':
+      request('snapshot'); // ports, addrs and roster are changed
+      break;
+  }
+}
+
+function details(d) {
+  if (!d) {
+    return '';
+  }
+
+  return ' (' + Object.keys(d).map((k) => k + ': ' + d[k]).join(', ') + ')';
+}
+
+function applySnapshot(s) {
+  seq = s.seq;
+  me.addr = s.addr;
+  me.peer = s.id;
+  $('state').textContent = s.state;
+  $('addr').textContent = s.addr || '-';
+
+  const ports = $('ports');
+  ports.innerHTML = '';
+  for (const cfg of s.ports) {
+    const li = document.createElement('li');
+    const behind = s.neighbors[cfg.name];
+    li.textContent = cfg.name + ' ' + cfg.baudRate + ' ' + (behind ? '→ ' + behind + ' ' : '');
+    const b = document.createElement('button');
+    b.textContent = 'Disconnect';
+    b.onclick = () => request('disconnect', {addr: cfg.name});
+    li.appendChild(b);
+    ports.appendChild(li);
+  }
+
+  const roster = $('roster');
+  roster.innerHTML = '';
+  peers.clear();
+  for (const n of s.roster || []) {
+    const li = document.createElement('li');
+    li.textContent = n.addr + ' ' + (n.nick || n.host || '') + (n.addr === s.addr ? ' (me)' : '');
+    if (n.id) {
+      peers.set(n.id, n);
+      li.dataset.id = n.id;
+      if (n.id !== me.peer) {
+        li.className = 'peer';
+        li.onclick = () => select(n.id);
+      }
+    }
+    roster.appendChild(li);
+  }
+
+  $('messages').innerHTML = '';
+  messages.clear();
+  convs.clear();
+  convs.add('all');
+  convs.add(current);
+  const pending = new Set(s.pending.map((m) => m.id));
+  for (const m of s.messages) {
+    const mine = m.peer === me.peer;
+    addMessage(m, mine);
+    if (mine && !pending.has(m.id)) {
+      setStatus(m.id, 'sent', {});
+    }
+  }
+  for (const m of s.pending) {
+    if (!messages.has(m.id)) {
+      addMessage(m, true);
+    }
+  }
+  renderConvs();
+}
+
+// convOf returns conversation of the message, peers are keyed by stable id,
+// so conversations survive new addrs after re-ring
+function convOf(m, mine) {
+  if (m.group) {
+    return '#' + m.group;
+  }
+  if (mine) {
+    return m.to ? (m.toPeer || m.to) : 'all';
+  }
+
+  return m.to === 'not_broadcast' ? (m.peer || m.addr) : 'all';
+}
+
+function titleOf(conv) {
+  if (conv === 'all') {
+    return 'everyone';
+  }
+  const n = peers.get(conv);
+  if (n) {
+    return (n.nick || n.host || n.id.slice(0, 8)) + ' (' + n.addr + ')';
+  }
+
+  return conv.length > 8 && !conv.startsWith('#') ? conv.slice(0, 8) : conv;
+}
+
+function renderConvs() {
+  const ul = $('conversations');
+  ul.innerHTML = '';
+  for (const conv of convs) {
+    const li = document.createElement('li');
+    li.textContent = titleOf(conv);
+    li.className = conv === current ? 'current' : '';
+    li.onclick = () => select(conv);
+    ul.appendChild(li);
+  }
+}
+
+// select shows messages of the conversation and sends to its peer or group
+function select(conv) {
+  current = conv;
+  convs.add(conv);
+  for (const li of $('messages').children) {
+    li.hidden = li.dataset.conv !== conv;
+  }
+  $('send').elements.addr.value = conv === 'all' ? '' : conv;
+  renderConvs();
+}
+
+function addMessage(m, mine) {
+  const li = document.createElement('li');
+  li.className = mine ? 'mine' : '';
+  li.dataset.conv = convOf(m, mine);
+  li.hidden = li.dataset.conv !== current;
+  if (!convs.has(li.dataset.conv)) {
+    convs.add(li.dataset.conv);
+    renderConvs();
+  }
+  const from = mine ? 'me' : (m.nick || m.addr);
+  const to = m.group ? ' #' + m.group : (m.to && mine ? ' → ' + m.to : '');
+  li.textContent = from + to + ': ' + m.message;
+  if (mine) {
+    const st = document.createElement('span');
+    st.className = 'status';
+    st.textContent = 'pending';
+    li.appendChild(st);
+    messages.set(m.id, st);
+  }
+  $('messages').appendChild(li);
+  li.scrollIntoView();
+}
+
+function setStatus(id, status, p) {
+  const st = messages.get(id);
+  if (!st) {
+    return;
+  }
+  st.className = 'status ' + status;
+  st.textContent = status.replace('_', ' ');
+  if (p.missed && p.missed.length) {
+    st.textContent += ', missed by ' + p.missed.join(', ');
+  }
+}
+
+function toast(text) {
+  const t = document.createElement('div');
+  t.className = 'toast';
+  t.textContent = text;
+  $('toasts').appendChild(t);
+  setTimeout(() => t.remove(), 5000);
+}
+
+$('connect').onsubmit = (e) => {
+  e.preventDefault();
+  const f = e.target;
+  request('connect', {
+    name: f.elements.name.value,
+    baudRate: +f.elements.baudRate.value,
+    size: +f.elements.size.value,
+    parity: f.elements.parity.value,
+    stopBits: +f.elements.stopBits.value,
+  });
+};
+
+$('ring-connect').onclick = () => request('ring_connect');
+$('ring-kill').onclick = () => request('kill_ring');
+
+$('send').onsubmit = (e) => {
+  e.preventDefault();
+  const f = e.target;
+  const to = f.elements.addr.value;
+  if (to.startsWith('#')) {
+    request('group_send', {group: to.slice(1), message: f.elements.message.value});
+  } else {
+    request('send', {addr: to, message: f.elements.message.value});
+  }
+  f.elements.message.value = '';
+};
+
+connect();
@@ -0,0 +1,16 @@
+// Package web is the chat and control UI of the node, it's built into
+// the binary, so the binary alone is a complete node.
+package web
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed index.html app.js style.css
+var files embed.FS
+
+// Handler serves the UI, it talks to the node by websocket protocol v2.
+func Handler() http.Handler {
+	return http.FileServer(http.FS(files))
+}